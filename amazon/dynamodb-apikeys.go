@@ -0,0 +1,179 @@
+package amazon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// APIKey is a long-lived credential for scripts and CI. The secret part is
+// only ever stored as a SHA-256 hash.
+type APIKey struct {
+	ID         string   `json:"id" dynamodbav:"id"`
+	UserID     string   `json:"userId" dynamodbav:"userId"`
+	Name       string   `json:"name" dynamodbav:"name"`
+	Scopes     []string `json:"scopes" dynamodbav:"scopes"`
+	Hash       string   `json:"-" dynamodbav:"hash"`
+	Revoked    bool     `json:"revoked" dynamodbav:"revoked"`
+	CreatedAt  int64    `json:"createdAt" dynamodbav:"createdAt"`
+	LastUsedAt int64    `json:"lastUsedAt,omitempty" dynamodbav:"lastUsedAt,omitempty"`
+}
+
+func CreateAPIKeysTable(client *dynamodb.Client, tableName string) error {
+	_, err := client.CreateTable(context.TODO(), &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("id"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("userId"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("id"),
+				KeyType:       types.KeyTypeHash, // Primary Key
+			},
+		},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+			{
+				IndexName: aws.String("userId-index"),
+				KeySchema: []types.KeySchemaElement{
+					{
+						AttributeName: aws.String("userId"),
+						KeyType:       types.KeyTypeHash,
+					},
+				},
+				Projection: &types.Projection{
+					ProjectionType: types.ProjectionTypeAll,
+				},
+			},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create api keys table: %w", err)
+	}
+
+	fmt.Println("✅ API keys table created:", tableName)
+	return nil
+}
+
+func CreateAPIKey(client *dynamodb.Client, tableName string, key APIKey) error {
+	av, err := attributevalue.MarshalMap(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName:           aws.String(tableName),
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create api key: %w", err)
+	}
+	return nil
+}
+
+func GetAPIKey(client *dynamodb.Client, tableName, id string) (*APIKey, error) {
+	out, err := client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, fmt.Errorf("api key %s not found", id)
+	}
+
+	var key APIKey
+	if err := attributevalue.UnmarshalMap(out.Item, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func GetUserAPIKeys(client *dynamodb.Client, tableName, userID string) ([]APIKey, error) {
+	out, err := client.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		IndexName:              aws.String("userId-index"),
+		KeyConditionExpression: aws.String("userId = :uid"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":uid": &types.AttributeValueMemberS{Value: userID},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []APIKey
+	if err := attributevalue.UnmarshalListOfMaps(out.Items, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey only revokes keys owned by userID and reports a missing or
+// foreign key as not found.
+func RevokeAPIKey(client *dynamodb.Client, tableName, id, userID string) error {
+	_, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("SET revoked = :true"),
+		ConditionExpression: aws.String("userId = :uid"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":true": &types.AttributeValueMemberBOOL{Value: true},
+			":uid":  &types.AttributeValueMemberS{Value: userID},
+		},
+	})
+	if err != nil {
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return fmt.Errorf("api key %s not found", id)
+		}
+		return fmt.Errorf("error revoking api key: %w", err)
+	}
+	return nil
+}
+
+func DeleteAPIKey(client *dynamodb.Client, tableName, id string) error {
+	_, err := client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting api key: %w", err)
+	}
+	return nil
+}
+
+func TouchAPIKey(client *dynamodb.Client, tableName, id string, usedAt int64) error {
+	_, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("SET lastUsedAt = :now"),
+		ConditionExpression: aws.String("attribute_exists(id)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", usedAt)},
+		},
+	})
+	return err
+}
@@ -0,0 +1,142 @@
+package amazon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// LoginAttempts counts recent failed logins for one key, either
+// "email#<address>" or "ip#<address>". Keeping the counters in DynamoDB
+// makes the limits hold across all server instances.
+type LoginAttempts struct {
+	ID            string `dynamodbav:"id"`
+	Failures      int    `dynamodbav:"failures"`
+	LastFailureAt int64  `dynamodbav:"lastFailureAt"`
+	LockedUntil   int64  `dynamodbav:"lockedUntil"`
+}
+
+func CreateLoginAttemptsTable(client *dynamodb.Client, tableName string) error {
+	_, err := client.CreateTable(context.TODO(), &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("id"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("id"),
+				KeyType:       types.KeyTypeHash, // Primary Key
+			},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create login attempts table: %w", err)
+	}
+
+	fmt.Println("✅ Login attempts table created:", tableName)
+	return nil
+}
+
+// GetLoginAttempts returns nil without an error if the key has no failures.
+func GetLoginAttempts(client *dynamodb.Client, tableName, id string) (*LoginAttempts, error) {
+	out, err := client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+
+	var attempts LoginAttempts
+	if err := attributevalue.UnmarshalMap(out.Item, &attempts); err != nil {
+		return nil, err
+	}
+	return &attempts, nil
+}
+
+// RecordLoginFailure atomically increments the failure counter. Failures
+// older than windowStart are forgotten and counting starts over at one.
+func RecordLoginFailure(client *dynamodb.Client, tableName, id string, now, windowStart int64) (*LoginAttempts, error) {
+	key := map[string]types.AttributeValue{
+		"id": &types.AttributeValueMemberS{Value: id},
+	}
+
+	out, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:           aws.String(tableName),
+		Key:                 key,
+		UpdateExpression:    aws.String("ADD failures :one SET lastFailureAt = :now"),
+		ConditionExpression: aws.String("lastFailureAt >= :windowStart"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one":         &types.AttributeValueMemberN{Value: "1"},
+			":now":         &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", now)},
+			":windowStart": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", windowStart)},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	})
+
+	var condErr *types.ConditionalCheckFailedException
+	if errors.As(err, &condErr) {
+		out, err = client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+			TableName:        aws.String(tableName),
+			Key:              key,
+			UpdateExpression: aws.String("SET failures = :one, lastFailureAt = :now, lockedUntil = :zero"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":one":  &types.AttributeValueMemberN{Value: "1"},
+				":now":  &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", now)},
+				":zero": &types.AttributeValueMemberN{Value: "0"},
+			},
+			ReturnValues: types.ReturnValueAllNew,
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error recording login failure: %w", err)
+	}
+
+	var attempts LoginAttempts
+	if err := attributevalue.UnmarshalMap(out.Attributes, &attempts); err != nil {
+		return nil, err
+	}
+	return &attempts, nil
+}
+
+func SetLockout(client *dynamodb.Client, tableName, id string, until int64) error {
+	_, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression: aws.String("SET lockedUntil = :until"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":until": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", until)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error setting lockout: %w", err)
+	}
+	return nil
+}
+
+func ClearLoginAttempts(client *dynamodb.Client, tableName, id string) error {
+	_, err := client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	return err
+}
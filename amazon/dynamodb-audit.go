@@ -0,0 +1,154 @@
+package amazon
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// AuditEvent records a privileged action: who did it (ActorID), to whom
+// (TargetID) and when. Events are append-only.
+type AuditEvent struct {
+	ID        string            `json:"id" dynamodbav:"id"`
+	Action    string            `json:"action" dynamodbav:"action"`
+	ActorID   string            `json:"actorId" dynamodbav:"actorId"`
+	TargetID  string            `json:"targetId" dynamodbav:"targetId"`
+	IP        string            `json:"ip,omitempty" dynamodbav:"ip,omitempty"`
+	Details   map[string]string `json:"details,omitempty" dynamodbav:"details,omitempty"`
+	CreatedAt int64             `json:"createdAt" dynamodbav:"createdAt"`
+}
+
+func CreateAuditLogTable(client *dynamodb.Client, tableName string) error {
+	_, err := client.CreateTable(context.TODO(), &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("id"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("targetId"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("createdAt"),
+				AttributeType: types.ScalarAttributeTypeN,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("id"),
+				KeyType:       types.KeyTypeHash, // Primary Key
+			},
+		},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+			{
+				IndexName: aws.String("targetId-index"),
+				KeySchema: []types.KeySchemaElement{
+					{
+						AttributeName: aws.String("targetId"),
+						KeyType:       types.KeyTypeHash,
+					},
+					{
+						AttributeName: aws.String("createdAt"),
+						KeyType:       types.KeyTypeRange,
+					},
+				},
+				Projection: &types.Projection{
+					ProjectionType: types.ProjectionTypeAll,
+				},
+			},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create audit log table: %w", err)
+	}
+
+	fmt.Println("✅ Audit log table created:", tableName)
+	return nil
+}
+
+func CreateAuditEvent(client *dynamodb.Client, tableName string, event AuditEvent) error {
+	av, err := attributevalue.MarshalMap(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName:           aws.String(tableName),
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write audit event: %w", err)
+	}
+	return nil
+}
+
+// GetAuditEventsForTarget returns the events about one user, newest first.
+func GetAuditEventsForTarget(client *dynamodb.Client, tableName, targetID string) ([]AuditEvent, error) {
+	var events []AuditEvent
+	var lastEvaluatedKey map[string]types.AttributeValue
+
+	for {
+		out, err := client.Query(context.TODO(), &dynamodb.QueryInput{
+			TableName:              aws.String(tableName),
+			IndexName:              aws.String("targetId-index"),
+			KeyConditionExpression: aws.String("targetId = :tid"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":tid": &types.AttributeValueMemberS{Value: targetID},
+			},
+			ScanIndexForward:  aws.Bool(false),
+			ExclusiveStartKey: lastEvaluatedKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var page []AuditEvent
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return nil, err
+		}
+		events = append(events, page...)
+
+		if out.LastEvaluatedKey == nil {
+			break
+		}
+		lastEvaluatedKey = out.LastEvaluatedKey
+	}
+
+	return events, nil
+}
+
+// PseudonymizeAuditEvent replaces the target of an event about an erased user
+// with a pseudonym, and the actor too when the user acted on their own
+// account, and drops the IP and details, which can name the user as well. It
+// is the one exception to the log being append-only, for erasing a user while
+// keeping the history of what happened.
+func PseudonymizeAuditEvent(client *dynamodb.Client, tableName string, event AuditEvent, pseudonym string) error {
+	update := "SET targetId = :p REMOVE ip, details"
+	if event.ActorID == event.TargetID {
+		update = "SET targetId = :p, actorId = :p REMOVE ip, details"
+	}
+
+	_, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: event.ID},
+		},
+		UpdateExpression:    aws.String(update),
+		ConditionExpression: aws.String("attribute_exists(id)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":p": &types.AttributeValueMemberS{Value: pseudonym},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to pseudonymize audit event: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package amazon
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ServiceClient is a machine principal (an internal worker) that gets tokens
+// through the client credentials grant. Its scopes are the most a token
+// issued to it can carry. The secret is only stored as a SHA-256 hash.
+type ServiceClient struct {
+	ID         string   `json:"clientId" dynamodbav:"id"`
+	Name       string   `json:"name" dynamodbav:"name"`
+	SecretHash string   `json:"-" dynamodbav:"secretHash"`
+	Scopes     []string `json:"scopes" dynamodbav:"scopes"`
+	Revoked    bool     `json:"revoked" dynamodbav:"revoked"`
+	CreatedBy  string   `json:"createdBy" dynamodbav:"createdBy"`
+	CreatedAt  int64    `json:"createdAt" dynamodbav:"createdAt"`
+}
+
+func CreateServiceClientsTable(client *dynamodb.Client, tableName string) error {
+	_, err := client.CreateTable(context.TODO(), &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("id"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("id"),
+				KeyType:       types.KeyTypeHash, // Primary Key
+			},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create service clients table: %w", err)
+	}
+
+	fmt.Println("✅ Service clients table created:", tableName)
+	return nil
+}
+
+func CreateServiceClient(client *dynamodb.Client, tableName string, sc ServiceClient) error {
+	av, err := attributevalue.MarshalMap(sc)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName:           aws.String(tableName),
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create service client: %w", err)
+	}
+	return nil
+}
+
+func GetServiceClient(client *dynamodb.Client, tableName, id string) (*ServiceClient, error) {
+	out, err := client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, fmt.Errorf("service client %s not found", id)
+	}
+
+	var sc ServiceClient
+	if err := attributevalue.UnmarshalMap(out.Item, &sc); err != nil {
+		return nil, err
+	}
+	return &sc, nil
+}
+
+func GetAllServiceClients(client *dynamodb.Client, tableName string) ([]ServiceClient, error) {
+	var clients []ServiceClient
+	var lastEvaluatedKey map[string]types.AttributeValue
+
+	for {
+		out, err := client.Scan(context.TODO(), &dynamodb.ScanInput{
+			TableName:         aws.String(tableName),
+			ExclusiveStartKey: lastEvaluatedKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var page []ServiceClient
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return nil, err
+		}
+		clients = append(clients, page...)
+
+		if out.LastEvaluatedKey == nil {
+			break
+		}
+		lastEvaluatedKey = out.LastEvaluatedKey
+	}
+
+	return clients, nil
+}
+
+func RevokeServiceClient(client *dynamodb.Client, tableName, id string) error {
+	_, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("SET revoked = :true"),
+		ConditionExpression: aws.String("attribute_exists(id)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":true": &types.AttributeValueMemberBOOL{Value: true},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error revoking service client: %w", err)
+	}
+	return nil
+}
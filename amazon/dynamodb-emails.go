@@ -0,0 +1,163 @@
+package amazon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// emailClaimsTable holds one EmailClaim per address in use.
+const emailClaimsTable = "user_emails"
+
+// EmailClaim reserves an address for one user. Users and claims are written
+// in the same transaction, so two accounts can never hold the same email.
+type EmailClaim struct {
+	Email  string `dynamodbav:"email"` // partition key
+	UserID string `dynamodbav:"userId"`
+}
+
+func CreateUserEmailsTable(client *dynamodb.Client, tableName string) error {
+	_, err := client.CreateTable(context.TODO(), &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("email"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("email"),
+				KeyType:       types.KeyTypeHash, // Primary Key
+			},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create user emails table: %w", err)
+	}
+
+	fmt.Println("✅ User emails table created:", tableName)
+	return nil
+}
+
+// putEmailClaim claims email for userID, failing if someone else holds it.
+func putEmailClaim(tableName, email, userID string) *types.Put {
+	return &types.Put{
+		TableName: aws.String(tableName),
+		Item: map[string]types.AttributeValue{
+			"email":  &types.AttributeValueMemberS{Value: email},
+			"userId": &types.AttributeValueMemberS{Value: userID},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(email) OR userId = :uid"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":uid": &types.AttributeValueMemberS{Value: userID},
+		},
+	}
+}
+
+// deleteEmailClaim releases email if userID holds it. Accounts created before
+// claims existed may have none, which is not an error.
+func deleteEmailClaim(tableName, email, userID string) *types.Delete {
+	return &types.Delete{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"email": &types.AttributeValueMemberS{Value: email},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(email) OR userId = :uid"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":uid": &types.AttributeValueMemberS{Value: userID},
+		},
+	}
+}
+
+// conditionFailedAt reports whether the transaction was cancelled because the
+// condition of its index-th item failed.
+func conditionFailedAt(err error, index int) bool {
+	var cancelled *types.TransactionCanceledException
+	if !errors.As(err, &cancelled) || index >= len(cancelled.CancellationReasons) {
+		return false
+	}
+	return aws.ToString(cancelled.CancellationReasons[index].Code) == "ConditionalCheckFailed"
+}
+
+// backfillMarker is the claims table item that records a finished backfill.
+// It has no @, so it can never be a real address.
+const backfillMarker = "#backfill-complete"
+
+// BackfillEmailClaims claims the address of every existing user, until one
+// run has finished. Claims are conditional puts, so a run that failed
+// partway is simply repeated on the next start. Addresses already held by two
+// accounts are logged and left with whichever account was seen first.
+func BackfillEmailClaims(client *dynamodb.Client, usersTable, emailsTable string) error {
+	waiter := dynamodb.NewTableExistsWaiter(client)
+	err := waiter.Wait(context.TODO(), &dynamodb.DescribeTableInput{TableName: aws.String(emailsTable)}, 2*time.Minute)
+	if err != nil {
+		return fmt.Errorf("waiting for %s table: %w", emailsTable, err)
+	}
+
+	marker, err := client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(emailsTable),
+		Key: map[string]types.AttributeValue{
+			"email": &types.AttributeValueMemberS{Value: backfillMarker},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return fmt.Errorf("checking backfill marker: %w", err)
+	}
+	if marker.Item != nil {
+		return nil
+	}
+
+	items, err := GetAllUsers(client, usersTable)
+	if err != nil {
+		return fmt.Errorf("listing users: %w", err)
+	}
+
+	var users []User
+	if err := attributevalue.UnmarshalListOfMaps(items, &users); err != nil {
+		return err
+	}
+
+	for _, u := range users {
+		if u.Email == "" {
+			continue
+		}
+		claim := putEmailClaim(emailsTable, u.Email, u.ID)
+		_, err := client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+			TableName:                 claim.TableName,
+			Item:                      claim.Item,
+			ConditionExpression:       claim.ConditionExpression,
+			ExpressionAttributeValues: claim.ExpressionAttributeValues,
+		})
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			log.Printf("Email of user %s is already claimed by another account", u.ID)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("claiming email of %s: %w", u.ID, err)
+		}
+	}
+
+	_, err = client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(emailsTable),
+		Item: map[string]types.AttributeValue{
+			"email":       &types.AttributeValueMemberS{Value: backfillMarker},
+			"completedAt": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", time.Now().Unix())},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("recording backfill: %w", err)
+	}
+	log.Printf("Claimed the emails of %d existing users", len(users))
+	return nil
+}
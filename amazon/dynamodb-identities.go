@@ -0,0 +1,133 @@
+package amazon
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Identity links an account at an external OIDC provider to a User.
+type Identity struct {
+	ID        string `json:"id" dynamodbav:"id"` // provider|subject
+	UserID    string `json:"userId" dynamodbav:"userId"`
+	Provider  string `json:"provider" dynamodbav:"provider"`
+	Subject   string `json:"subject" dynamodbav:"subject"`
+	Email     string `json:"email" dynamodbav:"email"`
+	CreatedAt int64  `json:"createdAt" dynamodbav:"createdAt"`
+}
+
+func IdentityID(provider, subject string) string {
+	return provider + "|" + subject
+}
+
+func CreateIdentitiesTable(client *dynamodb.Client, tableName string) error {
+	_, err := client.CreateTable(context.TODO(), &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("id"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("id"),
+				KeyType:       types.KeyTypeHash, // Primary Key
+			},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create identities table: %w", err)
+	}
+
+	fmt.Println("✅ Identities table created:", tableName)
+	return nil
+}
+
+// GetIdentity returns nil without an error when the identity is not linked yet.
+func GetIdentity(client *dynamodb.Client, tableName, id string) (*Identity, error) {
+	out, err := client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+
+	var identity Identity
+	if err := attributevalue.UnmarshalMap(out.Item, &identity); err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func LinkIdentity(client *dynamodb.Client, tableName string, identity Identity) error {
+	av, err := attributevalue.MarshalMap(identity)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName:           aws.String(tableName),
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to link identity: %w", err)
+	}
+	return nil
+}
+
+// GetUserIdentities scans for the identities linked to a user. The table has
+// no userId index, so this is only meant for rare jobs like account purges.
+func GetUserIdentities(client *dynamodb.Client, tableName, userID string) ([]Identity, error) {
+	input := &dynamodb.ScanInput{
+		TableName:        aws.String(tableName),
+		FilterExpression: aws.String("userId = :uid"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":uid": &types.AttributeValueMemberS{Value: userID},
+		},
+	}
+
+	var identities []Identity
+	for {
+		out, err := client.Scan(context.TODO(), input)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning identities: %w", err)
+		}
+
+		var page []Identity
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return nil, err
+		}
+		identities = append(identities, page...)
+
+		if out.LastEvaluatedKey == nil {
+			return identities, nil
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+}
+
+func DeleteIdentity(client *dynamodb.Client, tableName, id string) error {
+	_, err := client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete identity: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package amazon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ErrCodeUsed is returned when a TOTP step or recovery code was already consumed.
+var ErrCodeUsed = errors.New("code has already been used")
+
+// SetPendingTOTPSecret stores a secret that is not enforced until EnableMFA
+// confirms the user's authenticator produces valid codes.
+func SetPendingTOTPSecret(client *dynamodb.Client, tableName, id, secret string) error {
+	_, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("SET totpSecret = :secret REMOVE totpLastStep"),
+		ConditionExpression: aws.String("attribute_exists(id) AND (attribute_not_exists(mfaEnabled) OR mfaEnabled = :false)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":secret": &types.AttributeValueMemberS{Value: secret},
+			":false":  &types.AttributeValueMemberBOOL{Value: false},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error storing totp secret: %w", err)
+	}
+	return nil
+}
+
+func EnableMFA(client *dynamodb.Client, tableName, id string, recoveryCodes []string, step int64) error {
+	updateBuilder := expression.UpdateBuilder{}.
+		Set(expression.Name("mfaEnabled"), expression.Value(true)).
+		Set(expression.Name("recoveryCodes"), expression.Value(recoveryCodes)).
+		Set(expression.Name("totpLastStep"), expression.Value(step))
+
+	expr, err := expression.NewBuilder().WithUpdate(bumpVersion(updateBuilder)).Build()
+	if err != nil {
+		return fmt.Errorf("error in expression builder: %w", err)
+	}
+
+	_, err = client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       aws.String("attribute_exists(totpSecret)"),
+	})
+	if err != nil {
+		return fmt.Errorf("error enabling mfa: %w", err)
+	}
+	return nil
+}
+
+func DisableMFA(client *dynamodb.Client, tableName, id string) error {
+	_, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("SET mfaEnabled = :false, " + versionBump + " REMOVE totpSecret, totpLastStep, recoveryCodes"),
+		ConditionExpression: aws.String("attribute_exists(id)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":false": &types.AttributeValueMemberBOOL{Value: false},
+			":zero":  &types.AttributeValueMemberN{Value: "0"},
+			":one":   &types.AttributeValueMemberN{Value: "1"},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error disabling mfa: %w", err)
+	}
+	return nil
+}
+
+// RecordTOTPStep advances the last accepted time step. The condition makes a
+// code usable once even when two logins race with the same code.
+func RecordTOTPStep(client *dynamodb.Client, tableName, id string, step int64) error {
+	_, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("SET totpLastStep = :step"),
+		ConditionExpression: aws.String("attribute_not_exists(totpLastStep) OR totpLastStep < :step"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":step": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", step)},
+		},
+	})
+	if err != nil {
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return ErrCodeUsed
+		}
+		return fmt.Errorf("error recording totp step: %w", err)
+	}
+	return nil
+}
+
+// UseRecoveryCode removes the recovery code stored at index, provided it still
+// holds hash, so each code works exactly once.
+func UseRecoveryCode(client *dynamodb.Client, tableName, id string, index int, hash string) error {
+	_, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String(fmt.Sprintf("REMOVE recoveryCodes[%d]", index)),
+		ConditionExpression: aws.String(fmt.Sprintf("recoveryCodes[%d] = :hash", index)),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":hash": &types.AttributeValueMemberS{Value: hash},
+		},
+	})
+	if err != nil {
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return ErrCodeUsed
+		}
+		return fmt.Errorf("error using recovery code: %w", err)
+	}
+	return nil
+}
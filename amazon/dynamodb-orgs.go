@@ -0,0 +1,429 @@
+package amazon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationDeclined = "declined"
+	InvitationRevoked  = "revoked"
+)
+
+// ErrInvitationClosed is returned when an invitation is no longer pending or
+// has expired.
+var ErrInvitationClosed = errors.New("invitation is no longer pending")
+
+type Organization struct {
+	ID        string `json:"id" dynamodbav:"id"`
+	Name      string `json:"name" dynamodbav:"name"`
+	CreatedBy string `json:"createdBy" dynamodbav:"createdBy"`
+	CreatedAt int64  `json:"createdAt" dynamodbav:"createdAt"`
+}
+
+// Membership links a user to an organization with a per-org role. The table
+// is keyed by org and user, with a userId index for "my organizations".
+type Membership struct {
+	OrgID    string `json:"orgId" dynamodbav:"orgId"`
+	UserID   string `json:"userId" dynamodbav:"userId"`
+	Role     string `json:"role" dynamodbav:"role"`
+	JoinedAt int64  `json:"joinedAt" dynamodbav:"joinedAt"`
+}
+
+type Invitation struct {
+	ID        string `json:"id" dynamodbav:"id"`
+	OrgID     string `json:"orgId" dynamodbav:"orgId"`
+	Email     string `json:"email" dynamodbav:"email"`
+	Role      string `json:"role" dynamodbav:"role"`
+	InvitedBy string `json:"invitedBy" dynamodbav:"invitedBy"`
+	Status    string `json:"status" dynamodbav:"status"`
+	CreatedAt int64  `json:"createdAt" dynamodbav:"createdAt"`
+	ExpiresAt int64  `json:"expiresAt" dynamodbav:"expiresAt"`
+}
+
+func CreateOrganizationsTable(client *dynamodb.Client, tableName string) error {
+	_, err := client.CreateTable(context.TODO(), &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("id"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("id"),
+				KeyType:       types.KeyTypeHash, // Primary Key
+			},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create organizations table: %w", err)
+	}
+
+	fmt.Println("✅ Organizations table created:", tableName)
+	return nil
+}
+
+func CreateMembershipsTable(client *dynamodb.Client, tableName string) error {
+	_, err := client.CreateTable(context.TODO(), &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("orgId"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("userId"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("orgId"),
+				KeyType:       types.KeyTypeHash, // Partition Key
+			},
+			{
+				AttributeName: aws.String("userId"),
+				KeyType:       types.KeyTypeRange, // Sort Key
+			},
+		},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+			{
+				IndexName: aws.String("userId-index"),
+				KeySchema: []types.KeySchemaElement{
+					{
+						AttributeName: aws.String("userId"),
+						KeyType:       types.KeyTypeHash,
+					},
+				},
+				Projection: &types.Projection{
+					ProjectionType: types.ProjectionTypeAll,
+				},
+			},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create memberships table: %w", err)
+	}
+
+	fmt.Println("✅ Memberships table created:", tableName)
+	return nil
+}
+
+func CreateInvitationsTable(client *dynamodb.Client, tableName string) error {
+	_, err := client.CreateTable(context.TODO(), &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("id"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("email"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("orgId"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("id"),
+				KeyType:       types.KeyTypeHash, // Primary Key
+			},
+		},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+			{
+				IndexName: aws.String("email-index"),
+				KeySchema: []types.KeySchemaElement{
+					{
+						AttributeName: aws.String("email"),
+						KeyType:       types.KeyTypeHash,
+					},
+				},
+				Projection: &types.Projection{
+					ProjectionType: types.ProjectionTypeAll,
+				},
+			},
+			{
+				IndexName: aws.String("orgId-index"),
+				KeySchema: []types.KeySchemaElement{
+					{
+						AttributeName: aws.String("orgId"),
+						KeyType:       types.KeyTypeHash,
+					},
+				},
+				Projection: &types.Projection{
+					ProjectionType: types.ProjectionTypeAll,
+				},
+			},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create invitations table: %w", err)
+	}
+
+	fmt.Println("✅ Invitations table created:", tableName)
+	return nil
+}
+
+func putNew(client *dynamodb.Client, tableName string, item interface{}, keyAttr string) error {
+	av, err := attributevalue.MarshalMap(item)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName:           aws.String(tableName),
+		Item:                av,
+		ConditionExpression: aws.String(fmt.Sprintf("attribute_not_exists(%s)", keyAttr)),
+	})
+	return err
+}
+
+func CreateOrganization(client *dynamodb.Client, tableName string, org Organization) error {
+	if err := putNew(client, tableName, org, "id"); err != nil {
+		return fmt.Errorf("failed to create organization: %w", err)
+	}
+	return nil
+}
+
+func GetOrganization(client *dynamodb.Client, tableName, id string) (*Organization, error) {
+	out, err := client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, fmt.Errorf("organization %s not found", id)
+	}
+
+	var org Organization
+	if err := attributevalue.UnmarshalMap(out.Item, &org); err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+func membershipKey(orgID, userID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"orgId":  &types.AttributeValueMemberS{Value: orgID},
+		"userId": &types.AttributeValueMemberS{Value: userID},
+	}
+}
+
+// AddMembership fails if the user is already a member.
+func AddMembership(client *dynamodb.Client, tableName string, m Membership) error {
+	if err := putNew(client, tableName, m, "userId"); err != nil {
+		return fmt.Errorf("failed to add member: %w", err)
+	}
+	return nil
+}
+
+// GetMembership returns nil without an error if the user is not a member.
+func GetMembership(client *dynamodb.Client, tableName, orgID, userID string) (*Membership, error) {
+	out, err := client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName:      aws.String(tableName),
+		Key:            membershipKey(orgID, userID),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+
+	var m Membership
+	if err := attributevalue.UnmarshalMap(out.Item, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func GetOrgMembers(client *dynamodb.Client, tableName, orgID string) ([]Membership, error) {
+	out, err := client.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		KeyConditionExpression: aws.String("orgId = :oid"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":oid": &types.AttributeValueMemberS{Value: orgID},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var members []Membership
+	if err := attributevalue.UnmarshalListOfMaps(out.Items, &members); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func GetUserMemberships(client *dynamodb.Client, tableName, userID string) ([]Membership, error) {
+	out, err := client.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		IndexName:              aws.String("userId-index"),
+		KeyConditionExpression: aws.String("userId = :uid"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":uid": &types.AttributeValueMemberS{Value: userID},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var memberships []Membership
+	if err := attributevalue.UnmarshalListOfMaps(out.Items, &memberships); err != nil {
+		return nil, err
+	}
+	return memberships, nil
+}
+
+func UpdateMembershipRole(client *dynamodb.Client, tableName, orgID, userID, role string) error {
+	_, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:           aws.String(tableName),
+		Key:                 membershipKey(orgID, userID),
+		UpdateExpression:    aws.String("SET #role = :role"),
+		ConditionExpression: aws.String("attribute_exists(userId)"),
+		ExpressionAttributeNames: map[string]string{
+			"#role": "role",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":role": &types.AttributeValueMemberS{Value: role},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error updating member role: %w", err)
+	}
+	return nil
+}
+
+func RemoveMembership(client *dynamodb.Client, tableName, orgID, userID string) error {
+	_, err := client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName),
+		Key:       membershipKey(orgID, userID),
+	})
+	if err != nil {
+		return fmt.Errorf("error removing member: %w", err)
+	}
+	return nil
+}
+
+func CreateInvitation(client *dynamodb.Client, tableName string, inv Invitation) error {
+	if err := putNew(client, tableName, inv, "id"); err != nil {
+		return fmt.Errorf("failed to create invitation: %w", err)
+	}
+	return nil
+}
+
+func GetInvitation(client *dynamodb.Client, tableName, id string) (*Invitation, error) {
+	out, err := client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, fmt.Errorf("invitation %s not found", id)
+	}
+
+	var inv Invitation
+	if err := attributevalue.UnmarshalMap(out.Item, &inv); err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+func queryInvitations(client *dynamodb.Client, tableName, index, attr, value string) ([]Invitation, error) {
+	out, err := client.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		IndexName:              aws.String(index),
+		KeyConditionExpression: aws.String(attr + " = :v"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":v": &types.AttributeValueMemberS{Value: value},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var invitations []Invitation
+	if err := attributevalue.UnmarshalListOfMaps(out.Items, &invitations); err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+func GetInvitationsByEmail(client *dynamodb.Client, tableName, email string) ([]Invitation, error) {
+	return queryInvitations(client, tableName, "email-index", "email", email)
+}
+
+func GetOrgInvitations(client *dynamodb.Client, tableName, orgID string) ([]Invitation, error) {
+	return queryInvitations(client, tableName, "orgId-index", "orgId", orgID)
+}
+
+// DeleteInvitation removes an invitation whatever its status, for erasing the
+// invited address.
+func DeleteInvitation(client *dynamodb.Client, tableName, id string) error {
+	_, err := client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting invitation: %w", err)
+	}
+	return nil
+}
+
+// CloseInvitation moves a pending, unexpired invitation to status. The
+// condition makes accepting, declining and revoking mutually exclusive.
+func CloseInvitation(client *dynamodb.Client, tableName, id, status string) error {
+	_, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("SET #status = :status"),
+		ConditionExpression: aws.String("#status = :pending AND expiresAt > :now"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":status":  &types.AttributeValueMemberS{Value: status},
+			":pending": &types.AttributeValueMemberS{Value: InvitationPending},
+			":now":     &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", time.Now().Unix())},
+		},
+	})
+	if err != nil {
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return ErrInvitationClosed
+		}
+		return fmt.Errorf("error updating invitation: %w", err)
+	}
+	return nil
+}
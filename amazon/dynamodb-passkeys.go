@@ -0,0 +1,158 @@
+package amazon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Passkey is a WebAuthn credential. The table is keyed by user ID with the
+// credential ID as sort key, so all passkeys of a user come back in one query.
+// Credential holds the library's credential record as JSON.
+type Passkey struct {
+	UserID     string `json:"-" dynamodbav:"userId"`
+	ID         string `json:"id" dynamodbav:"id"` // base64url credential ID
+	Name       string `json:"name" dynamodbav:"name"`
+	Credential string `json:"-" dynamodbav:"credential"`
+	CreatedAt  int64  `json:"createdAt" dynamodbav:"createdAt"`
+	LastUsedAt int64  `json:"lastUsedAt,omitempty" dynamodbav:"lastUsedAt,omitempty"`
+}
+
+func CreatePasskeysTable(client *dynamodb.Client, tableName string) error {
+	_, err := client.CreateTable(context.TODO(), &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("userId"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("id"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("userId"),
+				KeyType:       types.KeyTypeHash, // Partition Key
+			},
+			{
+				AttributeName: aws.String("id"),
+				KeyType:       types.KeyTypeRange, // Sort Key
+			},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create passkeys table: %w", err)
+	}
+
+	fmt.Println("✅ Passkeys table created:", tableName)
+	return nil
+}
+
+func passkeyKey(userID, id string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"userId": &types.AttributeValueMemberS{Value: userID},
+		"id":     &types.AttributeValueMemberS{Value: id},
+	}
+}
+
+func CreatePasskey(client *dynamodb.Client, tableName string, passkey Passkey) error {
+	av, err := attributevalue.MarshalMap(passkey)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName:           aws.String(tableName),
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create passkey: %w", err)
+	}
+	return nil
+}
+
+func GetUserPasskeys(client *dynamodb.Client, tableName, userID string) ([]Passkey, error) {
+	out, err := client.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		KeyConditionExpression: aws.String("userId = :uid"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":uid": &types.AttributeValueMemberS{Value: userID},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var passkeys []Passkey
+	if err := attributevalue.UnmarshalListOfMaps(out.Items, &passkeys); err != nil {
+		return nil, err
+	}
+	return passkeys, nil
+}
+
+// UpdatePasskeyUsage stores the credential after a login, which carries the
+// new signature counter, and records when it was used.
+func UpdatePasskeyUsage(client *dynamodb.Client, tableName, userID, id, credential string, usedAt int64) error {
+	_, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:           aws.String(tableName),
+		Key:                 passkeyKey(userID, id),
+		UpdateExpression:    aws.String("SET credential = :cred, lastUsedAt = :now"),
+		ConditionExpression: aws.String("attribute_exists(id)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":cred": &types.AttributeValueMemberS{Value: credential},
+			":now":  &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", usedAt)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error updating passkey: %w", err)
+	}
+	return nil
+}
+
+func RenamePasskey(client *dynamodb.Client, tableName, userID, id, name string) error {
+	_, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:           aws.String(tableName),
+		Key:                 passkeyKey(userID, id),
+		UpdateExpression:    aws.String("SET #name = :name"),
+		ConditionExpression: aws.String("attribute_exists(id)"),
+		ExpressionAttributeNames: map[string]string{
+			"#name": "name",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":name": &types.AttributeValueMemberS{Value: name},
+		},
+	})
+	if err != nil {
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return fmt.Errorf("passkey %s not found", id)
+		}
+		return fmt.Errorf("error renaming passkey: %w", err)
+	}
+	return nil
+}
+
+func DeletePasskey(client *dynamodb.Client, tableName, userID, id string) error {
+	_, err := client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName:           aws.String(tableName),
+		Key:                 passkeyKey(userID, id),
+		ConditionExpression: aws.String("attribute_exists(id)"),
+	})
+	if err != nil {
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return fmt.Errorf("passkey %s not found", id)
+		}
+		return fmt.Errorf("error deleting passkey: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package amazon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ErrTokenReused is returned by RotateSession when the presented refresh token
+// is no longer the current token of its session (or the session is revoked).
+var ErrTokenReused = errors.New("refresh token has already been used")
+
+// Session is one refresh token family. Every rotation replaces TokenID, so
+// only the most recently issued refresh token of a session is accepted.
+// UserAgent, IP and LastSeenAt describe the device as of the last login or
+// refresh, for the user's session list.
+type Session struct {
+	ID         string `json:"id" dynamodbav:"id"`
+	UserID     string `json:"userId" dynamodbav:"userId"`
+	ActorID    string `json:"actorId,omitempty" dynamodbav:"actorId,omitempty"` // admin, for impersonation sessions
+	TokenID    string `json:"-" dynamodbav:"tokenId"`
+	Revoked    bool   `json:"revoked" dynamodbav:"revoked"`
+	OrgID      string `json:"orgId,omitempty" dynamodbav:"orgId,omitempty"`
+	UserAgent  string `json:"userAgent,omitempty" dynamodbav:"userAgent,omitempty"`
+	IP         string `json:"ip,omitempty" dynamodbav:"ip,omitempty"`
+	CreatedAt  int64  `json:"createdAt" dynamodbav:"createdAt"`
+	LastSeenAt int64  `json:"lastSeenAt,omitempty" dynamodbav:"lastSeenAt,omitempty"`
+	ExpiresAt  int64  `json:"expiresAt" dynamodbav:"expiresAt"`
+	Current    bool   `json:"current" dynamodbav:"-"`
+}
+
+func CreateSessionsTable(client *dynamodb.Client, tableName string) error {
+	_, err := client.CreateTable(context.TODO(), &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("id"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("userId"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("id"),
+				KeyType:       types.KeyTypeHash, // Primary Key
+			},
+		},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+			{
+				IndexName: aws.String("userId-index"),
+				KeySchema: []types.KeySchemaElement{
+					{
+						AttributeName: aws.String("userId"),
+						KeyType:       types.KeyTypeHash,
+					},
+				},
+				Projection: &types.Projection{
+					ProjectionType: types.ProjectionTypeAll,
+				},
+			},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create sessions table: %w", err)
+	}
+
+	fmt.Println("✅ Sessions table created:", tableName)
+	return nil
+}
+
+func CreateSession(client *dynamodb.Client, tableName string, session Session) error {
+	av, err := attributevalue.MarshalMap(session)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName:           aws.String(tableName),
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	return nil
+}
+
+func GetSessionById(client *dynamodb.Client, tableName, id string) (*Session, error) {
+	out, err := client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, fmt.Errorf("session %s not found", id)
+	}
+
+	var session Session
+	if err := attributevalue.UnmarshalMap(out.Item, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func GetUserSessions(client *dynamodb.Client, tableName, userID string) ([]Session, error) {
+	var sessions []Session
+	var lastEvaluatedKey map[string]types.AttributeValue
+
+	for {
+		out, err := client.Query(context.TODO(), &dynamodb.QueryInput{
+			TableName:              aws.String(tableName),
+			IndexName:              aws.String("userId-index"),
+			KeyConditionExpression: aws.String("userId = :uid"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":uid": &types.AttributeValueMemberS{Value: userID},
+			},
+			ExclusiveStartKey: lastEvaluatedKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var page []Session
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, page...)
+
+		if out.LastEvaluatedKey == nil {
+			break
+		}
+		lastEvaluatedKey = out.LastEvaluatedKey
+	}
+
+	return sessions, nil
+}
+
+// RotateSession swaps the current refresh token of a session for a new one.
+// The write only succeeds if oldTokenID is still current and the session has
+// not been revoked, so two concurrent refreshes cannot both win.
+func RotateSession(client *dynamodb.Client, tableName, oldTokenID string, next Session) error {
+	_, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: next.ID},
+		},
+		UpdateExpression:    aws.String("SET tokenId = :new, expiresAt = :exp, lastSeenAt = :seen, userAgent = :ua, ip = :ip"),
+		ConditionExpression: aws.String("tokenId = :old AND revoked = :false"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":new":   &types.AttributeValueMemberS{Value: next.TokenID},
+			":old":   &types.AttributeValueMemberS{Value: oldTokenID},
+			":exp":   &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", next.ExpiresAt)},
+			":seen":  &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", next.LastSeenAt)},
+			":ua":    &types.AttributeValueMemberS{Value: next.UserAgent},
+			":ip":    &types.AttributeValueMemberS{Value: next.IP},
+			":false": &types.AttributeValueMemberBOOL{Value: false},
+		},
+	})
+	if err != nil {
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return ErrTokenReused
+		}
+		return fmt.Errorf("error rotating session: %w", err)
+	}
+	return nil
+}
+
+func RevokeSession(client *dynamodb.Client, tableName, id string) error {
+	_, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("SET revoked = :true"),
+		ConditionExpression: aws.String("attribute_exists(id)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":true": &types.AttributeValueMemberBOOL{Value: true},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error revoking session: %w", err)
+	}
+	return nil
+}
+
+func RevokeUserSessions(client *dynamodb.Client, tableName, userID string) error {
+	sessions, err := GetUserSessions(client, tableName, userID)
+	if err != nil {
+		return fmt.Errorf("error listing sessions: %w", err)
+	}
+
+	for _, s := range sessions {
+		if s.Revoked {
+			continue
+		}
+		if err := RevokeSession(client, tableName, s.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteUserSessions removes every session of a user, revoked or not, and
+// returns how many there were.
+func DeleteUserSessions(client *dynamodb.Client, tableName, userID string) (int, error) {
+	sessions, err := GetUserSessions(client, tableName, userID)
+	if err != nil {
+		return 0, fmt.Errorf("error listing sessions: %w", err)
+	}
+
+	for _, s := range sessions {
+		_, err := client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+			TableName: aws.String(tableName),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: s.ID},
+			},
+		})
+		if err != nil {
+			return 0, fmt.Errorf("error deleting session: %w", err)
+		}
+	}
+	return len(sessions), nil
+}
+
+// SetSessionOrg records the active organization of a session, so refreshed
+// access tokens keep it. An empty orgID switches back to the personal space.
+func SetSessionOrg(client *dynamodb.Client, tableName, id, orgID string) error {
+	_, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("SET orgId = :org"),
+		ConditionExpression: aws.String("attribute_exists(id) AND revoked = :false"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":org":   &types.AttributeValueMemberS{Value: orgID},
+			":false": &types.AttributeValueMemberBOOL{Value: false},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error setting session organization: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package amazon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ErrTokenInvalid is returned when a one-time token is unknown, expired,
+// already used or was issued for a different purpose.
+var ErrTokenInvalid = errors.New("token is invalid or expired")
+
+// OneTimeToken backs emailed links (verification, password reset, ...).
+// Only a hash of the token is stored, the raw value lives in the link.
+type OneTimeToken struct {
+	ID        string            `dynamodbav:"id"` // sha256 of the raw token
+	Purpose   string            `dynamodbav:"purpose"`
+	UserID    string            `dynamodbav:"userId"`
+	Email     string            `dynamodbav:"email"`
+	Used      bool              `dynamodbav:"used"`
+	CreatedAt int64             `dynamodbav:"createdAt"`
+	ExpiresAt int64             `dynamodbav:"expiresAt"`
+	Data      map[string]string `dynamodbav:"data,omitempty"`
+}
+
+func CreateTokensTable(client *dynamodb.Client, tableName string) error {
+	_, err := client.CreateTable(context.TODO(), &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("id"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("id"),
+				KeyType:       types.KeyTypeHash, // Primary Key
+			},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create tokens table: %w", err)
+	}
+
+	fmt.Println("✅ Tokens table created:", tableName)
+	return nil
+}
+
+func SaveOneTimeToken(client *dynamodb.Client, tableName string, token OneTimeToken) error {
+	av, err := attributevalue.MarshalMap(token)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName:           aws.String(tableName),
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save token: %w", err)
+	}
+	return nil
+}
+
+// GetOneTimeToken returns a token that is still valid for purpose without
+// using it up. Only ConsumeOneTimeToken decides whether a token is redeemed.
+func GetOneTimeToken(client *dynamodb.Client, tableName, id, purpose string) (*OneTimeToken, error) {
+	out, err := client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting token: %w", err)
+	}
+	if out.Item == nil {
+		return nil, ErrTokenInvalid
+	}
+
+	var token OneTimeToken
+	if err := attributevalue.UnmarshalMap(out.Item, &token); err != nil {
+		return nil, err
+	}
+	if token.Used || token.Purpose != purpose || token.ExpiresAt <= time.Now().Unix() {
+		return nil, ErrTokenInvalid
+	}
+	return &token, nil
+}
+
+// DeleteUserTokens removes every token issued to userID or to email, used or
+// not, and returns how many there were. The table has no index on either, so
+// this scans it, which is fine for the rare account erasure.
+func DeleteUserTokens(client *dynamodb.Client, tableName, userID, email string) (int, error) {
+	var ids []string
+	var lastEvaluatedKey map[string]types.AttributeValue
+
+	for {
+		out, err := client.Scan(context.TODO(), &dynamodb.ScanInput{
+			TableName:            aws.String(tableName),
+			FilterExpression:     aws.String("userId = :uid OR email = :email"),
+			ProjectionExpression: aws.String("id"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":uid":   &types.AttributeValueMemberS{Value: userID},
+				":email": &types.AttributeValueMemberS{Value: email},
+			},
+			ExclusiveStartKey: lastEvaluatedKey,
+		})
+		if err != nil {
+			return 0, fmt.Errorf("error listing tokens: %w", err)
+		}
+
+		var page []OneTimeToken
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return 0, err
+		}
+		for _, t := range page {
+			ids = append(ids, t.ID)
+		}
+
+		if out.LastEvaluatedKey == nil {
+			break
+		}
+		lastEvaluatedKey = out.LastEvaluatedKey
+	}
+
+	for _, id := range ids {
+		_, err := client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+			TableName: aws.String(tableName),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: id},
+			},
+		})
+		if err != nil {
+			return 0, fmt.Errorf("error deleting token: %w", err)
+		}
+	}
+	return len(ids), nil
+}
+
+// ConsumeOneTimeToken marks the token as used and returns it. The conditional
+// write guarantees a token can be consumed at most once.
+func ConsumeOneTimeToken(client *dynamodb.Client, tableName, id, purpose string) (*OneTimeToken, error) {
+	return consumeOneTimeToken(client, tableName, id, purpose, "")
+}
+
+// ConsumeOneTimeTokenForEmail only consumes a token issued for email, so a
+// token presented with the wrong address stays valid for the right one.
+func ConsumeOneTimeTokenForEmail(client *dynamodb.Client, tableName, id, purpose, email string) (*OneTimeToken, error) {
+	if email == "" {
+		return nil, ErrTokenInvalid
+	}
+	return consumeOneTimeToken(client, tableName, id, purpose, email)
+}
+
+func consumeOneTimeToken(client *dynamodb.Client, tableName, id, purpose, email string) (*OneTimeToken, error) {
+	condition := "attribute_exists(id) AND used = :false AND purpose = :purpose AND expiresAt > :now"
+	values := map[string]types.AttributeValue{
+		":true":    &types.AttributeValueMemberBOOL{Value: true},
+		":false":   &types.AttributeValueMemberBOOL{Value: false},
+		":purpose": &types.AttributeValueMemberS{Value: purpose},
+		":now":     &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", time.Now().Unix())},
+	}
+	if email != "" {
+		condition += " AND email = :email"
+		values[":email"] = &types.AttributeValueMemberS{Value: email}
+	}
+
+	out, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:          aws.String("SET used = :true"),
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeValues: values,
+		ReturnValues:              types.ReturnValueAllNew,
+	})
+	if err != nil {
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return nil, ErrTokenInvalid
+		}
+		return nil, fmt.Errorf("error consuming token: %w", err)
+	}
+
+	var token OneTimeToken
+	if err := attributevalue.UnmarshalMap(out.Attributes, &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
@@ -2,7 +2,9 @@ package amazon
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -12,11 +14,78 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
+var (
+	// ErrThrottled is returned when an action was repeated too soon.
+	ErrThrottled = errors.New("too many requests")
+
+	ErrUserNotFound    = errors.New("user not found")
+	ErrEmailTaken      = errors.New("email is already in use")
+	ErrNothingToUpdate = errors.New("must update at least one field")
+	ErrVersionConflict = errors.New("user was modified concurrently")
+)
+
+const (
+	EmailUnverified = "unverified"
+	EmailVerified   = "verified"
+)
+
+// Account states. Deactivated accounts can be reactivated at any time,
+// deleted ones are restorable until PurgeAt, when the purge job removes them.
+const (
+	UserActive      = "active"
+	UserDeactivated = "deactivated"
+	UserDeleted     = "deleted"
+)
+
 type User struct {
-	ID       string `json:"id" dynamodbav:"id"`
-	Name     string `json:"name" dynamodbav:"name"`
-	Email    string `json:"email" dynamodbav:"email"`
-	Password string `json:"password" dynamodbav:"password"`
+	ID                 string   `json:"id" dynamodbav:"id"`
+	Name               string   `json:"name" dynamodbav:"name"`
+	Email              string   `json:"email" dynamodbav:"email"`
+	Password           string   `json:"-" dynamodbav:"password"`
+	Roles              []string `json:"roles" dynamodbav:"roles,omitempty"`
+	EmailStatus        string   `json:"emailStatus,omitempty" dynamodbav:"emailStatus,omitempty"`
+	VerificationSentAt int64    `json:"-" dynamodbav:"verificationSentAt,omitempty"`
+	ExportRequestedAt  int64    `json:"-" dynamodbav:"exportRequestedAt,omitempty"`
+	MagicLinkSentAt    int64    `json:"-" dynamodbav:"magicLinkSentAt,omitempty"`
+	ResetSentAt        int64    `json:"-" dynamodbav:"resetSentAt,omitempty"`
+	MFAEnabled         bool     `json:"mfaEnabled" dynamodbav:"mfaEnabled,omitempty"`
+	TOTPSecret         string   `json:"-" dynamodbav:"totpSecret,omitempty"`
+	TOTPLastStep       int64    `json:"-" dynamodbav:"totpLastStep,omitempty"`
+	RecoveryCodes      []string `json:"-" dynamodbav:"recoveryCodes,omitempty"` // sha256 hashes
+	CreatedAt          int64    `json:"createdAt,omitempty" dynamodbav:"createdAt,omitempty"`
+	Status             string   `json:"status,omitempty" dynamodbav:"status,omitempty"`
+	PurgeAt            int64    `json:"purgeAt,omitempty" dynamodbav:"purgeAt,omitempty"`
+	Version            int64    `json:"version,omitempty" dynamodbav:"version,omitempty"`
+
+	DisplayName string                 `json:"displayName,omitempty" dynamodbav:"displayName,omitempty"`
+	Bio         string                 `json:"bio,omitempty" dynamodbav:"bio,omitempty"`
+	Timezone    string                 `json:"timezone,omitempty" dynamodbav:"timezone,omitempty"`
+	Locale      string                 `json:"locale,omitempty" dynamodbav:"locale,omitempty"`
+	AvatarKey   string                 `json:"avatarKey,omitempty" dynamodbav:"avatarKey,omitempty"` // S3 object key
+	Preferences map[string]interface{} `json:"preferences,omitempty" dynamodbav:"preferences,omitempty"`
+}
+
+// ProfileUpdate holds the profile fields to change. Nil fields are left
+// alone, empty strings remove the attribute. Name cannot be removed.
+// Preferences replaces the stored document when non-nil.
+type ProfileUpdate struct {
+	Name        *string
+	DisplayName *string
+	Bio         *string
+	Timezone    *string
+	Locale      *string
+	Preferences map[string]interface{}
+}
+
+// IsEmailVerified treats accounts created before email verification existed
+// (no emailStatus attribute) as verified.
+func (u User) IsEmailVerified() bool {
+	return u.EmailStatus != EmailUnverified
+}
+
+// IsActive treats accounts without a status attribute as active.
+func (u User) IsActive() bool {
+	return u.Status == "" || u.Status == UserActive
 }
 
 func CreateUsersTable(client *dynamodb.Client, tableName string) error {
@@ -57,30 +126,26 @@ func CreateUsersTable(client *dynamodb.Client, tableName string) error {
 	return err
 }
 
+// CreateUser writes a new user together with the claim on its email, in one
+// transaction. An address that is already claimed fails with ErrEmailTaken.
 func CreateUser(client *dynamodb.Client, tableName string, item map[string]types.AttributeValue) error {
+	id := item["id"].(*types.AttributeValueMemberS).Value
 	email := item["email"].(*types.AttributeValueMemberS).Value
-
-	queryOut, err := client.Query(context.TODO(), &dynamodb.QueryInput{
-		TableName:              aws.String(tableName),
-		IndexName:              aws.String("email-index"),
-		KeyConditionExpression: aws.String("email = :e"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":e": &types.AttributeValueMemberS{Value: email},
+	item["version"] = &types.AttributeValueMemberN{Value: "1"}
+
+	_, err := client.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Put: &types.Put{
+				TableName:           aws.String(tableName),
+				Item:                item,
+				ConditionExpression: aws.String("attribute_not_exists(id)"),
+			}},
+			{Put: putEmailClaim(emailClaimsTable, email, id)},
 		},
-		Limit: aws.Int32(1),
 	})
-	if err != nil {
-		return fmt.Errorf("failed to query GSI: %w", err)
+	if conditionFailedAt(err, 1) {
+		return fmt.Errorf("user with email %s already exists: %w", email, ErrEmailTaken)
 	}
-
-	if len(queryOut.Items) > 0 {
-		return fmt.Errorf("user with email %s already exists", email)
-	}
-
-	_, err = client.PutItem(context.TODO(), &dynamodb.PutItemInput{
-		TableName: aws.String(tableName),
-		Item:      item,
-	})
 	if err != nil {
 		return fmt.Errorf("failed to create user: %w", err)
 	}
@@ -101,6 +166,24 @@ func GetUserById(client *dynamodb.Client, tableName, id string) (map[string]type
 	return result.Item, nil
 }
 
+// FindUserById is GetUserById decoded into a User, with a missing item
+// reported as an error.
+func FindUserById(client *dynamodb.Client, tableName, id string) (*User, error) {
+	item, err := GetUserById(client, tableName, id)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return nil, fmt.Errorf("user with ID %s: %w", id, ErrUserNotFound)
+	}
+
+	var user User
+	if err := attributevalue.UnmarshalMap(item, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
 func GetAllUsers(client *dynamodb.Client, tableName string) ([]map[string]types.AttributeValue, error) {
 	var items []map[string]types.AttributeValue
 	var lastEvaluatedKey map[string]types.AttributeValue
@@ -125,6 +208,118 @@ func GetAllUsers(client *dynamodb.Client, tableName string) ([]map[string]types.
 	return items, nil
 }
 
+// UserFilter narrows a user listing. Empty fields match everything. Name
+// prefixes are case sensitive, email domains are not.
+type UserFilter struct {
+	NamePrefix   string
+	EmailDomain  string
+	Role         string
+	CreatedAfter int64 // unix seconds, users without createdAt never match
+}
+
+func (f UserFilter) Matches(u User) bool {
+	if f.NamePrefix != "" && !strings.HasPrefix(u.Name, f.NamePrefix) {
+		return false
+	}
+	if f.EmailDomain != "" && !strings.HasSuffix(u.Email, "@"+strings.ToLower(f.EmailDomain)) {
+		return false
+	}
+	if f.Role != "" && !slices.Contains(u.Roles, f.Role) {
+		return false
+	}
+	if f.CreatedAfter != 0 && u.CreatedAt <= f.CreatedAfter {
+		return false
+	}
+	return true
+}
+
+// condition is a server side pre-filter for Matches. DynamoDB has no
+// ends_with, so the email domain is only narrowed down with contains.
+func (f UserFilter) condition() (expression.ConditionBuilder, bool) {
+	var conds []expression.ConditionBuilder
+	if f.NamePrefix != "" {
+		conds = append(conds, expression.Name("name").BeginsWith(f.NamePrefix))
+	}
+	if f.EmailDomain != "" {
+		conds = append(conds, expression.Name("email").Contains("@"+strings.ToLower(f.EmailDomain)))
+	}
+	if f.Role != "" {
+		conds = append(conds, expression.Name("roles").Contains(f.Role))
+	}
+	if f.CreatedAfter != 0 {
+		conds = append(conds, expression.Name("createdAt").GreaterThan(expression.Value(f.CreatedAfter)))
+	}
+
+	switch len(conds) {
+	case 0:
+		return expression.ConditionBuilder{}, false
+	case 1:
+		return conds[0], true
+	default:
+		return expression.And(conds[0], conds[1], conds[2:]...), true
+	}
+}
+
+// ScanUsers returns up to limit users matching filter, starting after the
+// user with ID startID. The returned ID is where the next page starts, or
+// empty when the table is exhausted. Users come back in table order.
+func ScanUsers(client *dynamodb.Client, tableName string, filter UserFilter, limit int, startID string) ([]User, string, error) {
+	if limit <= 0 {
+		return nil, "", fmt.Errorf("limit must be positive")
+	}
+
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(tableName),
+		// read a little ahead, filters can drop most of a page
+		Limit: aws.Int32(int32(max(limit, 25))),
+	}
+
+	if cond, ok := filter.condition(); ok {
+		expr, err := expression.NewBuilder().WithFilter(cond).Build()
+		if err != nil {
+			return nil, "", fmt.Errorf("error building user filter: %w", err)
+		}
+		input.FilterExpression = expr.Filter()
+		input.ExpressionAttributeNames = expr.Names()
+		input.ExpressionAttributeValues = expr.Values()
+	}
+
+	if startID != "" {
+		input.ExclusiveStartKey = map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: startID},
+		}
+	}
+
+	var users []User
+	for {
+		out, err := client.Scan(context.TODO(), input)
+		if err != nil {
+			return nil, "", fmt.Errorf("error scanning users: %w", err)
+		}
+
+		var page []User
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return nil, "", err
+		}
+		for _, u := range page {
+			if filter.Matches(u) {
+				users = append(users, u)
+			}
+		}
+
+		// the last user returned is a valid start key for the next scan,
+		// even when it sits in the middle of what DynamoDB read
+		if len(users) > limit || (len(users) == limit && out.LastEvaluatedKey != nil) {
+			users = users[:limit]
+			return users, users[limit-1].ID, nil
+		}
+		if out.LastEvaluatedKey == nil {
+			return users, "", nil
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+}
+
 func GetUserByEmail(client *dynamodb.Client, tableName, email string) (*User, error) {
 	email = strings.ToLower(email)
 
@@ -143,7 +338,7 @@ func GetUserByEmail(client *dynamodb.Client, tableName, email string) (*User, er
 	}
 
 	if len(result.Items) == 0 {
-		return nil, err
+		return nil, fmt.Errorf("user with email %s: %w", email, ErrUserNotFound)
 	}
 
 	var user User
@@ -155,61 +350,172 @@ func GetUserByEmail(client *dynamodb.Client, tableName, email string) (*User, er
 	return &user, nil
 }
 
-func UpdateUser(client *dynamodb.Client, tableName string, user User) error {
-	updateBuilder := expression.UpdateBuilder{}
-	updatedFields := 0
+// UpdateUser changes the non-empty name and email of user.ID, provided the
+// stored version is still version. A changed email moves the user's claim to
+// the new address in the same transaction.
+func UpdateUser(client *dynamodb.Client, tableName string, user User, version int64) error {
+	if user.Email == "" && user.Name == "" {
+		return ErrNothingToUpdate
+	}
 
 	getOut, err := client.GetItem(context.TODO(), &dynamodb.GetItemInput{
 		TableName: aws.String(tableName),
 		Key: map[string]types.AttributeValue{
 			"id": &types.AttributeValueMemberS{Value: user.ID},
 		},
+		ConsistentRead: aws.Bool(true),
 	})
 	if err != nil {
-		return fmt.Errorf("error checking user existence: %w", err)
+		return fmt.Errorf("error reading user: %w", err)
 	}
 	if getOut.Item == nil {
-		return fmt.Errorf("user with ID %s not found", user.ID)
+		return fmt.Errorf("user with ID %s: %w", user.ID, ErrUserNotFound)
 	}
 
-	if user.Email != "" {
-		expr, err := expression.NewBuilder().
-			WithFilter(expression.Name("email").Equal(expression.Value(user.Email))).
-			Build()
-		if err != nil {
-			return fmt.Errorf("error building email check expression: %w", err)
-		}
+	var current User
+	if err := attributevalue.UnmarshalMap(getOut.Item, &current); err != nil {
+		return err
+	}
+	if current.Version != version {
+		return fmt.Errorf("user with ID %s: %w", user.ID, ErrVersionConflict)
+	}
 
-		scanOut, err := client.Scan(context.TODO(), &dynamodb.ScanInput{
-			TableName:                 aws.String(tableName),
-			FilterExpression:          expr.Filter(),
-			ExpressionAttributeNames:  expr.Names(),
-			ExpressionAttributeValues: expr.Values(),
+	updateBuilder := bumpVersion(expression.UpdateBuilder{})
+	emailChanged := user.Email != "" && user.Email != current.Email
+	if emailChanged {
+		// a new address has to be verified again
+		updateBuilder = updateBuilder.
+			Set(expression.Name("email"), expression.Value(user.Email)).
+			Set(expression.Name("emailStatus"), expression.Value(EmailUnverified))
+	}
+	if user.Name != "" {
+		updateBuilder = updateBuilder.Set(expression.Name("name"), expression.Value(user.Name))
+	}
+
+	expr, err := expression.NewBuilder().
+		WithUpdate(updateBuilder).
+		WithCondition(versionIs(version)).
+		Build()
+	if err != nil {
+		return fmt.Errorf("error in expression builder: %w", err)
+	}
+
+	update := &types.Update{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: user.ID},
+		},
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+	}
+
+	if !emailChanged {
+		_, err = client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+			TableName:                 update.TableName,
+			Key:                       update.Key,
+			ExpressionAttributeNames:  update.ExpressionAttributeNames,
+			ExpressionAttributeValues: update.ExpressionAttributeValues,
+			UpdateExpression:          update.UpdateExpression,
+			ConditionExpression:       update.ConditionExpression,
 		})
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return fmt.Errorf("user with ID %s: %w", user.ID, ErrVersionConflict)
+		}
 		if err != nil {
-			return fmt.Errorf("error checking for duplicate email: %w", err)
+			return fmt.Errorf("error updating user: %w", err)
 		}
+		return nil
+	}
 
-		for _, item := range scanOut.Items {
-			if idAttr, ok := item["id"].(*types.AttributeValueMemberS); ok && idAttr.Value != user.ID {
-				return fmt.Errorf("email %s is already in use", user.Email)
-			}
-		}
+	_, err = client.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Update: update},
+			{Put: putEmailClaim(emailClaimsTable, user.Email, user.ID)},
+			{Delete: deleteEmailClaim(emailClaimsTable, current.Email, user.ID)},
+		},
+	})
+	switch {
+	case conditionFailedAt(err, 0):
+		return fmt.Errorf("user with ID %s: %w", user.ID, ErrVersionConflict)
+	case conditionFailedAt(err, 1):
+		return fmt.Errorf("email %s: %w", user.Email, ErrEmailTaken)
+	case err != nil:
+		return fmt.Errorf("error updating user: %w", err)
+	}
+	return nil
+}
+
+// bumpVersion adds the version increment every write to a user makes.
+// Users stored before versioning count as version 0.
+func bumpVersion(updateBuilder expression.UpdateBuilder) expression.UpdateBuilder {
+	version := expression.Name("version")
+	return updateBuilder.Set(version, expression.Plus(version.IfNotExists(expression.Value(0)), expression.Value(1)))
+}
 
-		updateBuilder = updateBuilder.Set(expression.Name("email"), expression.Value(user.Email))
+// versionBump is bumpVersion for hand-written update expressions, with the
+// values :zero and :one.
+const versionBump = "version = if_not_exists(version, :zero) + :one"
+
+// versionIs is the condition for an optimistic write against version.
+func versionIs(version int64) expression.ConditionBuilder {
+	if version == 0 {
+		return expression.Name("id").AttributeExists().And(expression.Name("version").AttributeNotExists())
+	}
+	return expression.Name("version").Equal(expression.Value(version))
+}
+
+// UpdateUserProfile writes profile if the user is still at version, so a
+// concurrent update cannot be overwritten with stale preferences.
+func UpdateUserProfile(client *dynamodb.Client, tableName, id string, profile ProfileUpdate, version int64) error {
+	updateBuilder := expression.UpdateBuilder{}
+	updatedFields := 0
+
+	if profile.Name != nil && *profile.Name != "" {
+		updateBuilder = updateBuilder.Set(expression.Name("name"), expression.Value(*profile.Name))
 		updatedFields++
 	}
 
-	if user.Name != "" {
-		updateBuilder = updateBuilder.Set(expression.Name("name"), expression.Value(user.Name))
+	optional := []struct {
+		name  string
+		value *string
+	}{
+		{"displayName", profile.DisplayName},
+		{"bio", profile.Bio},
+		{"timezone", profile.Timezone},
+		{"locale", profile.Locale},
+	}
+	for _, field := range optional {
+		if field.value == nil {
+			continue
+		}
+		if *field.value == "" {
+			updateBuilder = updateBuilder.Remove(expression.Name(field.name))
+		} else {
+			updateBuilder = updateBuilder.Set(expression.Name(field.name), expression.Value(*field.value))
+		}
+		updatedFields++
+	}
+
+	if profile.Preferences != nil {
+		if len(profile.Preferences) == 0 {
+			updateBuilder = updateBuilder.Remove(expression.Name("preferences"))
+		} else {
+			updateBuilder = updateBuilder.Set(expression.Name("preferences"), expression.Value(profile.Preferences))
+		}
 		updatedFields++
 	}
 
 	if updatedFields == 0 {
-		return fmt.Errorf("must update at least one field")
+		return ErrNothingToUpdate
 	}
 
-	expr, err := expression.NewBuilder().WithUpdate(updateBuilder).Build()
+	expr, err := expression.NewBuilder().
+		WithUpdate(bumpVersion(updateBuilder)).
+		WithCondition(versionIs(version)).
+		Build()
 	if err != nil {
 		return fmt.Errorf("error in expression builder: %w", err)
 	}
@@ -217,21 +523,142 @@ func UpdateUser(client *dynamodb.Client, tableName string, user User) error {
 	_, err = client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
 		TableName: aws.String(tableName),
 		Key: map[string]types.AttributeValue{
-			"id": &types.AttributeValueMemberS{Value: user.ID},
+			"id": &types.AttributeValueMemberS{Value: id},
 		},
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
 		UpdateExpression:          expr.Update(),
-		ConditionExpression:       aws.String("attribute_exists(id)"),
-		ReturnValues:              types.ReturnValueUpdatedNew,
+		ConditionExpression:       expr.Condition(),
 	})
 	if err != nil {
-		return fmt.Errorf("error updating user: %w", err)
+		return versionedUpdateErr(id, "updating profile", err)
+	}
+
+	return nil
+}
+
+// SetUserAvatar points the user at a new avatar object, or removes the avatar
+// when key is empty. Like UpdateUserProfile it only writes at version.
+func SetUserAvatar(client *dynamodb.Client, tableName, id, key string, version int64) error {
+	updateBuilder := expression.UpdateBuilder{}.Remove(expression.Name("avatarKey"))
+	if key != "" {
+		updateBuilder = expression.UpdateBuilder{}.Set(expression.Name("avatarKey"), expression.Value(key))
+	}
+
+	expr, err := expression.NewBuilder().
+		WithUpdate(bumpVersion(updateBuilder)).
+		WithCondition(versionIs(version)).
+		Build()
+	if err != nil {
+		return fmt.Errorf("error in expression builder: %w", err)
+	}
+
+	_, err = client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+	})
+	if err != nil {
+		return versionedUpdateErr(id, "updating avatar", err)
 	}
+	return nil
+}
+
+// versionedUpdateErr reports a failed versionIs condition as
+// ErrVersionConflict. A deleted user fails the same way, and is reported as
+// not found by the read that follows.
+func versionedUpdateErr(id, action string, err error) error {
+	var condErr *types.ConditionalCheckFailedException
+	if errors.As(err, &condErr) {
+		return fmt.Errorf("user with ID %s: %w", id, ErrVersionConflict)
+	}
+	return fmt.Errorf("error %s: %w", action, err)
+}
 
+// userUpdateErr reports a failed attribute_exists(id) condition as
+// ErrUserNotFound.
+func userUpdateErr(id, action string, err error) error {
+	var condErr *types.ConditionalCheckFailedException
+	if errors.As(err, &condErr) {
+		return fmt.Errorf("user with ID %s: %w", id, ErrUserNotFound)
+	}
+	return fmt.Errorf("error %s: %w", action, err)
+}
+
+// SetUserStatus moves a user to status. purgeAt is only kept for deleted
+// users.
+func SetUserStatus(client *dynamodb.Client, tableName, id, status string, purgeAt int64) error {
+	updateBuilder := expression.UpdateBuilder{}.
+		Set(expression.Name("status"), expression.Value(status))
+	if status == UserDeleted {
+		updateBuilder = updateBuilder.Set(expression.Name("purgeAt"), expression.Value(purgeAt))
+	} else {
+		updateBuilder = updateBuilder.Remove(expression.Name("purgeAt"))
+	}
+
+	expr, err := expression.NewBuilder().WithUpdate(bumpVersion(updateBuilder)).Build()
+	if err != nil {
+		return fmt.Errorf("error in expression builder: %w", err)
+	}
+
+	_, err = client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       aws.String("attribute_exists(id)"),
+	})
+	if err != nil {
+		return userUpdateErr(id, "updating status", err)
+	}
 	return nil
 }
 
+// GetUsersDueForPurge lists deleted users whose grace period ended by now.
+func GetUsersDueForPurge(client *dynamodb.Client, tableName string, now int64) ([]User, error) {
+	expr, err := expression.NewBuilder().WithFilter(
+		expression.Name("status").Equal(expression.Value(UserDeleted)).
+			And(expression.Name("purgeAt").LessThanEqual(expression.Value(now))),
+	).Build()
+	if err != nil {
+		return nil, fmt.Errorf("error building purge filter: %w", err)
+	}
+
+	input := &dynamodb.ScanInput{
+		TableName:                 aws.String(tableName),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}
+
+	var users []User
+	for {
+		out, err := client.Scan(context.TODO(), input)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning for deleted users: %w", err)
+		}
+
+		var page []User
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return nil, err
+		}
+		users = append(users, page...)
+
+		if out.LastEvaluatedKey == nil {
+			return users, nil
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+}
+
 func UpdatePassword(client *dynamodb.Client, tableName string, user User) error {
 	if user.ID == "" || user.Password == "" {
 		return fmt.Errorf("missing user ID or password")
@@ -247,13 +674,13 @@ func UpdatePassword(client *dynamodb.Client, tableName string, user User) error
 		return fmt.Errorf("error checking user existence: %w", err)
 	}
 	if getOut.Item == nil {
-		return fmt.Errorf("user with ID %s not found", user.ID)
+		return fmt.Errorf("user with ID %s: %w", user.ID, ErrUserNotFound)
 	}
 
 	updateBuilder := expression.UpdateBuilder{}.
 		Set(expression.Name("password"), expression.Value(user.Password))
 
-	expr, err := expression.NewBuilder().WithUpdate(updateBuilder).Build()
+	expr, err := expression.NewBuilder().WithUpdate(bumpVersion(updateBuilder)).Build()
 	if err != nil {
 		return fmt.Errorf("error in expression builder: %w", err)
 	}
@@ -276,12 +703,175 @@ func UpdatePassword(client *dynamodb.Client, tableName string, user User) error
 	return nil
 }
 
+// DeleteUser removes the user and releases its email. Deleting a user that
+// does not exist is not an error.
 func DeleteUser(client *dynamodb.Client, tableName, id string) error {
-	_, err := client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+	user, err := FindUserById(client, tableName, id)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = client.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Delete: &types.Delete{
+				TableName: aws.String(tableName),
+				Key: map[string]types.AttributeValue{
+					"id": &types.AttributeValueMemberS{Value: id},
+				},
+			}},
+			{Delete: deleteEmailClaim(emailClaimsTable, user.Email, id)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting user: %w", err)
+	}
+	return nil
+}
+
+func UpdateUserRoles(client *dynamodb.Client, tableName, id string, roles []string) error {
+	updateBuilder := expression.UpdateBuilder{}.
+		Set(expression.Name("roles"), expression.Value(roles))
+
+	expr, err := expression.NewBuilder().WithUpdate(bumpVersion(updateBuilder)).Build()
+	if err != nil {
+		return fmt.Errorf("error in expression builder: %w", err)
+	}
+
+	_, err = client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
 		TableName: aws.String(tableName),
 		Key: map[string]types.AttributeValue{
 			"id": &types.AttributeValueMemberS{Value: id},
 		},
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       aws.String("attribute_exists(id)"),
 	})
-	return err
+	if err != nil {
+		return userUpdateErr(id, "updating roles", err)
+	}
+
+	return nil
+}
+
+func SetEmailVerified(client *dynamodb.Client, tableName, id string) error {
+	_, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("SET emailStatus = :verified, " + versionBump + " REMOVE verificationSentAt"),
+		ConditionExpression: aws.String("attribute_exists(id)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":verified": &types.AttributeValueMemberS{Value: EmailVerified},
+			":zero":     &types.AttributeValueMemberN{Value: "0"},
+			":one":      &types.AttributeValueMemberN{Value: "1"},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error verifying email: %w", err)
+	}
+	return nil
+}
+
+// ClaimVerificationSend records that a verification email is being sent. It
+// fails if the previous one was sent after notBefore, which throttles resends.
+func ClaimVerificationSend(client *dynamodb.Client, tableName, id string, sentAt, notBefore int64) error {
+	_, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("SET verificationSentAt = :now"),
+		ConditionExpression: aws.String("attribute_exists(id) AND (attribute_not_exists(verificationSentAt) OR verificationSentAt < :notBefore)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now":       &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", sentAt)},
+			":notBefore": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", notBefore)},
+		},
+	})
+	if err != nil {
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return ErrThrottled
+		}
+		return fmt.Errorf("error recording verification email: %w", err)
+	}
+	return nil
+}
+
+// ClaimMagicLinkSend is ClaimVerificationSend for sign-in links.
+func ClaimMagicLinkSend(client *dynamodb.Client, tableName, id string, sentAt, notBefore int64) error {
+	_, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("SET magicLinkSentAt = :now"),
+		ConditionExpression: aws.String("attribute_exists(id) AND (attribute_not_exists(magicLinkSentAt) OR magicLinkSentAt < :notBefore)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now":       &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", sentAt)},
+			":notBefore": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", notBefore)},
+		},
+	})
+	if err != nil {
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return ErrThrottled
+		}
+		return fmt.Errorf("error recording magic link: %w", err)
+	}
+	return nil
+}
+
+// ClaimResetSend is ClaimVerificationSend for password reset links.
+func ClaimResetSend(client *dynamodb.Client, tableName, id string, sentAt, notBefore int64) error {
+	_, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("SET resetSentAt = :now"),
+		ConditionExpression: aws.String("attribute_exists(id) AND (attribute_not_exists(resetSentAt) OR resetSentAt < :notBefore)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now":       &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", sentAt)},
+			":notBefore": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", notBefore)},
+		},
+	})
+	if err != nil {
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return ErrThrottled
+		}
+		return fmt.Errorf("error recording reset email: %w", err)
+	}
+	return nil
+}
+
+// ClaimExportRequest records that a data export is starting. It fails if the
+// previous one was requested after notBefore, so concurrent requests cannot
+// both start one.
+func ClaimExportRequest(client *dynamodb.Client, tableName, id string, requestedAt, notBefore int64) error {
+	_, err := client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("SET exportRequestedAt = :now"),
+		ConditionExpression: aws.String("attribute_exists(id) AND (attribute_not_exists(exportRequestedAt) OR exportRequestedAt < :notBefore)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now":       &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", requestedAt)},
+			":notBefore": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", notBefore)},
+		},
+	})
+	if err != nil {
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return ErrThrottled
+		}
+		return fmt.Errorf("error recording export request: %w", err)
+	}
+	return nil
 }
@@ -11,7 +11,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/aws/aws-sdk-go-v2/service/sso/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
 func ConnectDB() (*dynamodb.Client, string) {
@@ -30,8 +30,20 @@ func ConnectDB() (*dynamodb.Client, string) {
 	ddbClient := dynamodb.NewFromConfig(ddbCfg)
 
 	tables := map[string]func(*dynamodb.Client, string) error{
-		"users": CreateUsersTable,
-		"files": CreateFilesTable,
+		"users":           CreateUsersTable,
+		"files":           CreateFilesTable,
+		"sessions":        CreateSessionsTable,
+		"tokens":          CreateTokensTable,
+		"identities":      CreateIdentitiesTable,
+		"api_keys":        CreateAPIKeysTable,
+		"login_attempts":  CreateLoginAttemptsTable,
+		"passkeys":        CreatePasskeysTable,
+		"audit_log":       CreateAuditLogTable,
+		"service_clients": CreateServiceClientsTable,
+		"organizations":   CreateOrganizationsTable,
+		"memberships":     CreateMembershipsTable,
+		"invitations":     CreateInvitationsTable,
+		"user_emails":     CreateUserEmailsTable,
 	}
 
 	for name, createFunc := range tables {
@@ -43,6 +55,19 @@ func ConnectDB() (*dynamodb.Client, string) {
 		log.Printf("%s table ready for data\n", name)
 	}
 
+	// org files came after the files table, older tables lack their index
+	if err := AddFilesOrgIndex(ddbClient, "files"); err != nil {
+		msg := fmt.Sprintf("Failed to add the files orgId index: %v", err)
+		return nil, msg
+	}
+
+	// email claims came after users, existing users get theirs until a
+	// backfill has completed once
+	if err := BackfillEmailClaims(ddbClient, "users", "user_emails"); err != nil {
+		msg := fmt.Sprintf("Failed to claim existing user emails: %v", err)
+		return nil, msg
+	}
+
 	log.Printf("Connected to DynamoDB\n")
 	return ddbClient, "Connected to DynamoDB"
 }
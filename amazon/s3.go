@@ -3,8 +3,8 @@ package amazon
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
-	"mime/multipart"
 	"os"
 	"time"
 
@@ -17,9 +17,12 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
+// UserFile belongs to the uploader's personal space, or to an organization
+// when OrgID is set. Org files are listed through the orgId-index.
 type UserFile struct {
 	UserID   string `dynamodbav:"userId"` // partition key
 	FileID   string `dynamodbav:"fileId"` // sort key
+	OrgID    string `dynamodbav:"orgId,omitempty"`
 	FileKey  string `dynamodbav:"fileKey"`
 	Uploaded int64  `dynamodbav:"uploaded"`
 }
@@ -61,6 +64,10 @@ func CreateFilesTable(client *dynamodb.Client, tableName string) error {
 				AttributeName: aws.String("fileId"),
 				AttributeType: types.ScalarAttributeTypeS,
 			},
+			{
+				AttributeName: aws.String("orgId"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
 		},
 		KeySchema: []types.KeySchemaElement{
 			{
@@ -72,6 +79,20 @@ func CreateFilesTable(client *dynamodb.Client, tableName string) error {
 				KeyType:       types.KeyTypeRange, // Sort key
 			},
 		},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+			{
+				IndexName: aws.String("orgId-index"),
+				KeySchema: []types.KeySchemaElement{
+					{
+						AttributeName: aws.String("orgId"),
+						KeyType:       types.KeyTypeHash,
+					},
+				},
+				Projection: &types.Projection{
+					ProjectionType: types.ProjectionTypeAll,
+				},
+			},
+		},
 		BillingMode: types.BillingModePayPerRequest, // On-demand billing
 	})
 	if err != nil {
@@ -82,7 +103,62 @@ func CreateFilesTable(client *dynamodb.Client, tableName string) error {
 	return nil
 }
 
-func UploadFile(client *s3.Client, presigner *s3.PresignClient, filename string, fileContent multipart.File) (string, string, error) {
+// AddFilesOrgIndex adds the orgId-index to a files table created before
+// organizations existed. DynamoDB builds the index in the background, org
+// file listings fail until it is active.
+func AddFilesOrgIndex(client *dynamodb.Client, tableName string) error {
+	waiter := dynamodb.NewTableExistsWaiter(client)
+	err := waiter.Wait(context.TODO(), &dynamodb.DescribeTableInput{TableName: aws.String(tableName)}, 2*time.Minute)
+	if err != nil {
+		return fmt.Errorf("waiting for %s table: %w", tableName, err)
+	}
+
+	out, err := client.DescribeTable(context.TODO(), &dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	})
+	if err != nil {
+		return fmt.Errorf("error describing %s table: %w", tableName, err)
+	}
+	for _, index := range out.Table.GlobalSecondaryIndexes {
+		if aws.ToString(index.IndexName) == "orgId-index" {
+			return nil
+		}
+	}
+
+	_, err = client.UpdateTable(context.TODO(), &dynamodb.UpdateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("orgId"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		GlobalSecondaryIndexUpdates: []types.GlobalSecondaryIndexUpdate{
+			{
+				Create: &types.CreateGlobalSecondaryIndexAction{
+					IndexName: aws.String("orgId-index"),
+					KeySchema: []types.KeySchemaElement{
+						{
+							AttributeName: aws.String("orgId"),
+							KeyType:       types.KeyTypeHash,
+						},
+					},
+					Projection: &types.Projection{
+						ProjectionType: types.ProjectionTypeAll,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add orgId-index to %s table: %w", tableName, err)
+	}
+
+	fmt.Println("✅ orgId-index added to", tableName)
+	return nil
+}
+
+func UploadFile(client *s3.Client, presigner *s3.PresignClient, filename string, fileContent io.Reader) (string, string, error) {
 	bucketName := os.Getenv("AWS_BUCKET")
 
 	fileKey := "uploads/" + filename
@@ -120,9 +196,9 @@ func SaveUserFile(dynamo *dynamodb.Client, tableName string, userFile UserFile)
 	return err
 }
 
-func GetUserFiles(dynamo *dynamodb.Client, userID string) ([]UserFile, error) {
+func GetUserFiles(dynamo *dynamodb.Client, tableName, userID string) ([]UserFile, error) {
 	out, err := dynamo.Query(context.TODO(), &dynamodb.QueryInput{
-		TableName:              aws.String("files"),
+		TableName:              aws.String(tableName),
 		KeyConditionExpression: aws.String("userId = :uid"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":uid": &types.AttributeValueMemberS{Value: userID},
@@ -140,6 +216,78 @@ func GetUserFiles(dynamo *dynamodb.Client, userID string) ([]UserFile, error) {
 	return files, nil
 }
 
+func GetOrgFiles(dynamo *dynamodb.Client, tableName, orgID string) ([]UserFile, error) {
+	out, err := dynamo.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		IndexName:              aws.String("orgId-index"),
+		KeyConditionExpression: aws.String("orgId = :oid"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":oid": &types.AttributeValueMemberS{Value: orgID},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var files []UserFile
+	if err := attributevalue.UnmarshalListOfMaps(out.Items, &files); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+func DeleteUserFile(dynamo *dynamodb.Client, tableName, userID, fileID string) error {
+	_, err := dynamo.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"userId": &types.AttributeValueMemberS{Value: userID},
+			"fileId": &types.AttributeValueMemberS{Value: fileID},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete file record: %w", err)
+	}
+	return nil
+}
+
+func DeleteFile(client *s3.Client, fileKey string) error {
+	_, err := client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+		Bucket: aws.String(os.Getenv("AWS_BUCKET")),
+		Key:    aws.String(fileKey),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	return nil
+}
+
+// ReadFile downloads a whole object into memory, for small files only.
+func ReadFile(client *s3.Client, fileKey string) ([]byte, error) {
+	out, err := client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(os.Getenv("AWS_BUCKET")),
+		Key:    aws.String(fileKey),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	defer out.Body.Close()
+
+	return io.ReadAll(out.Body)
+}
+
+// PresignFile returns a download link for any object, valid for ttl.
+func PresignFile(presigner *s3.PresignClient, fileKey string, ttl time.Duration) (string, error) {
+	presignedReq, err := presigner.PresignGetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(os.Getenv("AWS_BUCKET")),
+		Key:    aws.String(fileKey),
+	}, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", fmt.Errorf("failed to presign URL: %w", err)
+	}
+	return presignedReq.URL, nil
+}
+
 func DownloadFile(client *s3.Client, filename string) (string, error) {
 
 	bucketName := os.Getenv("AWS_BUCKET")
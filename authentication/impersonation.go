@@ -0,0 +1,70 @@
+package authentication
+
+import (
+	"errors"
+	"net/http"
+	"time"
+	"xstudious-guide/amazon"
+	"xstudious-guide/store"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ImpersonationTTL is deliberately short and impersonation tokens come
+// without a refresh token, support has to start a new session to continue.
+const ImpersonationTTL = 15 * time.Minute
+
+var ErrCannotImpersonate = errors.New("this user cannot be impersonated")
+
+// NewImpersonationClaims returns access token claims for target with the
+// admin recorded in the act claim. Admins cannot be impersonated, so an
+// impersonation token never carries more power than a regular user's.
+func NewImpersonationClaims(actor *UserClaims, target amazon.User) (UserClaims, error) {
+	if actor.Actor != nil || actor.ID == target.ID || containsString(PermissionsForRoles(target.Roles), PermUsersAdmin) {
+		return UserClaims{}, ErrCannotImpersonate
+	}
+
+	claims := NewUserClaims(target)
+	claims.Actor = &Actor{ID: actor.ID, Email: actor.Email}
+	claims.Purpose = "impersonation"
+	claims.Id = uuid.NewString()
+	claims.ExpiresAt = time.Now().Add(ImpersonationTTL).Unix()
+	return claims, nil
+}
+
+// NewImpersonationSession records the session an impersonation token belongs
+// to. It is owned by the target, so it shows up in their device list and is
+// revoked with their other sessions, and has no refresh token.
+func NewImpersonationSession(sessions store.SessionStore, claims *UserClaims, ip string) error {
+	now := time.Now()
+	session := amazon.Session{
+		ID:         uuid.NewString(),
+		UserID:     claims.ID,
+		ActorID:    claims.Actor.ID,
+		UserAgent:  "Impersonation by " + claims.Actor.Email,
+		IP:         ip,
+		CreatedAt:  now.Unix(),
+		LastSeenAt: now.Unix(),
+		ExpiresAt:  claims.ExpiresAt,
+	}
+	if err := sessions.CreateSession(session); err != nil {
+		return err
+	}
+
+	claims.SessionID = session.ID
+	return nil
+}
+
+// DenyImpersonation blocks routes an admin must not use on someone else's
+// behalf, such as changing the password or managing credentials.
+func DenyImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if claims := GetClaims(c); claims != nil && claims.Actor != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed while impersonating"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
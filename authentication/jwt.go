@@ -1,39 +1,21 @@
 package authentication
 
 import (
-	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strings"
 	"time"
 	"xstudious-guide/amazon"
+	"xstudious-guide/store"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt"
-	"github.com/joho/godotenv"
-	"golang.org/x/crypto/bcrypt"
 )
 
-func HashedPassword(password string) (string, error) {
-	hashedPassword, error := bcrypt.GenerateFromPassword([]byte(password), 10)
-	return string(hashedPassword), error
-}
-
-func CheckPasswordHash(password, hash string) bool {
-	if password == "" || hash == "" {
-		return false
-	}
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
-}
-
 var (
 	AccessTokenSecret  string
 	RefreshTokenSecret string
@@ -41,54 +23,90 @@ var (
 	RefreshTokenTTL    = time.Hour * 24 * 7
 )
 
-// Load .env once at startup
+// InitAuth reads the token secrets and signing keys once at startup. The
+// environment (including .env) is loaded by main.
 func InitAuth() {
-	envPath := filepath.Join(".", ".env") // this points to fluffy-octo-tribble/.env
+	AccessTokenSecret = os.Getenv("TOKEN_SECRET")
+	RefreshTokenSecret = os.Getenv("REFRESH_TOKEN_SECRET")
+	ErasureReceiptSecret = os.Getenv("ERASURE_RECEIPT_SECRET")
 
-	if err := godotenv.Load(envPath); err != nil {
-		log.Fatalf("Error loading .env file at %s: %v", envPath, err)
+	if err := LoadSigningKeys(); err != nil {
+		log.Fatal(err)
 	}
 
-	AccessTokenSecret = os.Getenv("TOKEN_SECRET")
-	RefreshTokenSecret = os.Getenv("REFRESH_TOKEN_SECRET")
+	if RefreshTokenSecret == "" || (AccessTokenSecret == "" && activeKey == nil) {
+		log.Fatal("REFRESH_TOKEN_SECRET and either TOKEN_SECRET or JWT_KEYS_DIR must be set")
+	}
+	if ErasureReceiptSecret == "" {
+		log.Fatal("ERASURE_RECEIPT_SECRET must be set")
+	}
+
+	if err := LoadPasswordPolicy(); err != nil {
+		log.Fatal(err)
+	}
+
+	LoadOIDCProviders()
 
-	if AccessTokenSecret == "" || RefreshTokenSecret == "" {
-		log.Fatal("TOKEN_SECRET or REFRESH_TOKEN_SECRET is missing")
+	if err := LoadWebAuthn(); err != nil {
+		log.Fatal(err)
 	}
 }
 
 type UserClaims struct {
-	ID        string `json:"id"`
-	Name      string `json:"name"`
-	Email     string `json:"email"`
-	TokenType string `json:"token_type"`
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Email       string   `json:"email"`
+	Roles       []string `json:"roles,omitempty"`
+	Verified    bool     `json:"email_verified"`
+	Scopes      []string `json:"scopes,omitempty"`
+	Purpose     string   `json:"purpose,omitempty"`
+	APIKeyID    string   `json:"api_key_id,omitempty"`
+	Actor       *Actor   `json:"act,omitempty"`
+	SessionID   string   `json:"sid,omitempty"`
+	SubjectType string   `json:"sub_type,omitempty"`
+	OrgID       string   `json:"org_id,omitempty"`
+	OrgRole     string   `json:"org_role,omitempty"`
+	TokenType   string   `json:"token_type"`
 	jwt.StandardClaims
 }
 
+// Actor is the RFC 8693 act claim: the admin acting as the token's user
+// during impersonation.
+type Actor struct {
+	ID    string `json:"sub"`
+	Email string `json:"email,omitempty"`
+}
+
 func NewAccessToken(claims UserClaims) (string, error) {
 	claims.TokenType = "access"
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return accessToken.SignedString([]byte(AccessTokenSecret))
+	return signAccessToken(claims)
 }
 
-func NewRefreshToken(claims jwt.StandardClaims) (string, error) {
+// RefreshClaims identifies the session (token family) a refresh token belongs
+// to; StandardClaims.Id holds the token's own ID within that family.
+type RefreshClaims struct {
+	SessionID string `json:"sid"`
+	jwt.StandardClaims
+}
+
+func NewRefreshToken(claims RefreshClaims) (string, error) {
 	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return refreshToken.SignedString([]byte(RefreshTokenSecret))
 }
 
 func ParseAccessToken(accessToken string) *UserClaims {
-	parsedAccessToken, err := jwt.ParseWithClaims(accessToken, &UserClaims{}, func(token *jwt.Token) (interface{}, error) {
-		// Ensure correct signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(AccessTokenSecret), nil
-	})
+	parsedAccessToken, err := jwt.ParseWithClaims(accessToken, &UserClaims{}, accessTokenKey)
 	if err != nil || !parsedAccessToken.Valid {
 		fmt.Println("Token verification failed:", err) // Debugging output
 		return nil
 	}
 
+	// other documents signed with the access token keys carry their own typ
+	if typ, _ := parsedAccessToken.Header["typ"].(string); typ != "JWT" {
+		fmt.Println("Unexpected token type:", typ)
+		return nil
+	}
+
 	claims, ok := parsedAccessToken.Claims.(*UserClaims)
 	if !ok {
 		fmt.Println("Failed to cast token claims")
@@ -98,8 +116,8 @@ func ParseAccessToken(accessToken string) *UserClaims {
 	return claims
 }
 
-func ParseRefreshToken(refreshToken string) *jwt.StandardClaims {
-	parsedRefreshToken, err := jwt.ParseWithClaims(refreshToken, &jwt.StandardClaims{}, func(token *jwt.Token) (interface{}, error) {
+func ParseRefreshToken(refreshToken string) *RefreshClaims {
+	parsedRefreshToken, err := jwt.ParseWithClaims(refreshToken, &RefreshClaims{}, func(token *jwt.Token) (interface{}, error) {
 		// Ensure correct signing method
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
@@ -111,7 +129,7 @@ func ParseRefreshToken(refreshToken string) *jwt.StandardClaims {
 		return nil
 	}
 
-	claims, ok := parsedRefreshToken.Claims.(*jwt.StandardClaims)
+	claims, ok := parsedRefreshToken.Claims.(*RefreshClaims)
 	if !ok {
 		fmt.Println("Failed to cast refresh token claims")
 		return nil
@@ -120,7 +138,9 @@ func ParseRefreshToken(refreshToken string) *jwt.StandardClaims {
 	return claims
 }
 
-func AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware accepts either "Bearer <access token>" or "ApiKey <key>"
+// and stores the resulting *UserClaims under "claims".
+func AuthMiddleware(client *dynamodb.Client, stores store.Stores) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -129,6 +149,19 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		if apiKey := strings.TrimPrefix(authHeader, "ApiKey "); apiKey != authHeader {
+			claims, err := authenticateAPIKey(stores.APIKeys, stores.Users, apiKey)
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+				c.Abort()
+				return
+			}
+
+			c.Set("claims", claims)
+			c.Next()
+			return
+		}
+
 		token := strings.TrimPrefix(authHeader, "Bearer ")
 		if token == authHeader {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token format"})
@@ -143,12 +176,52 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		if claims.TokenType == "refresh" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh tokens cannot be used here"})
+		if claims.TokenType != "access" || claims.APIKeyID != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Only access tokens can be used here"})
 			c.Abort()
 			return
 		}
 
+		// a revoked session (logout, lost device, password reset) also
+		// invalidates the access tokens issued for it
+		if claims.SessionID != "" {
+			session, err := stores.Sessions.GetSession(claims.SessionID)
+			if err != nil || session.Revoked || session.UserID != claims.ID {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Session is no longer valid"})
+				c.Abort()
+				return
+			}
+		} else if !claims.IsClient() {
+			// deactivating an account revokes its sessions, tokens without
+			// one have to check the account itself
+			user, err := stores.Users.GetUser(claims.ID)
+			if err != nil || !user.IsActive() {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Account is no longer active"})
+				c.Abort()
+				return
+			}
+		}
+
+		// service client tokens stop working as soon as the client is revoked
+		if claims.IsClient() {
+			sc, err := amazon.GetServiceClient(client, "service_clients", claims.ID)
+			if err != nil || sc.Revoked {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Client is no longer valid"})
+				c.Abort()
+				return
+			}
+		}
+
+		if claims.OrgID != "" && !checkActiveOrg(client, claims) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Organization membership is no longer valid"})
+			c.Abort()
+			return
+		}
+
+		if claims.Actor != nil {
+			auditImpersonatedRequest(client, c, claims)
+		}
+
 		c.Set("claims", claims)
 		c.Next()
 	}
@@ -158,7 +231,7 @@ type RefreshRequest struct {
 	RefreshToken string `json:"refreshToken" binding:"required"`
 }
 
-func RefreshTokenHandler(client *dynamodb.Client) gin.HandlerFunc {
+func RefreshTokenHandler(client *dynamodb.Client, users store.UserStore, sessions store.SessionStore) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req RefreshRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -167,50 +240,57 @@ func RefreshTokenHandler(client *dynamodb.Client) gin.HandlerFunc {
 		}
 
 		claims := ParseRefreshToken(req.RefreshToken)
-		if claims == nil {
+		if claims == nil || claims.SessionID == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
 			return
 		}
 
-		userID := claims.Subject
+		session, err := sessions.GetSession(claims.SessionID)
+		if err != nil || session.Revoked || session.UserID != claims.Subject || session.ActorID != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session is no longer valid"})
+			return
+		}
 
-		out, err := client.GetItem(context.TODO(), &dynamodb.GetItemInput{
-			TableName: aws.String("users"), // adjust table name
-			Key: map[string]types.AttributeValue{
-				"id": &types.AttributeValueMemberS{Value: userID},
-			},
-		})
-		if err != nil || out.Item == nil {
+		// A refresh token that is not the current one of its family has been
+		// used before, so the family is assumed stolen and revoked entirely.
+		if session.TokenID != claims.Id {
+			revokeReusedSession(sessions, session.ID)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token reuse detected, session revoked"})
+			return
+		}
+
+		user, err := users.GetUser(claims.Subject)
+		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
 			return
 		}
 
-		var user amazon.User
-		if err := attributevalue.UnmarshalMap(out.Item, &user); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unmarshal user"})
+		// sessions are revoked on deactivation, this covers any that slipped through
+		if !user.IsActive() {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Account is not active"})
 			return
 		}
 
-		newClaims := UserClaims{
-			ID:        user.ID,
-			Name:      user.Name,
-			Email:     user.Email,
-			TokenType: "access",
-			StandardClaims: jwt.StandardClaims{
-				ExpiresAt: time.Now().Add(AccessTokenTTL).Unix(),
-				IssuedAt:  time.Now().Unix(),
-				Subject:   user.ID,
-			},
+		refreshToken, err := rotateRefreshToken(sessions, session, claims.Id, c.Request.UserAgent(), c.ClientIP())
+		if errors.Is(err, amazon.ErrTokenReused) {
+			revokeReusedSession(sessions, session.ID)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token reuse detected, session revoked"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate refresh token"})
+			return
 		}
 
-		accessToken, err := NewAccessToken(newClaims)
+		accessToken, err := NewSessionAccessToken(client, *user, *session)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate access token"})
 			return
 		}
 
 		c.JSON(http.StatusOK, gin.H{
-			"accessToken": accessToken,
+			"accessToken":  accessToken,
+			"refreshToken": refreshToken,
 		})
 	}
 }
@@ -0,0 +1,208 @@
+package authentication
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+)
+
+// signingKey is one entry of the access token keyset. Keys without a private
+// half are retired: they still verify tokens but never sign new ones.
+type signingKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.Signer
+	Public  crypto.PublicKey
+}
+
+var (
+	signingKeys = map[string]*signingKey{}
+	activeKey   *signingKey
+)
+
+// LoadSigningKeys reads every PEM file in JWT_KEYS_DIR; the file name without
+// extension(s) is the kid. JWT_ACTIVE_KID picks the key used for signing,
+// all others are only published and accepted. To rotate, add the new key,
+// wait for downstream JWKS caches to pick it up, switch JWT_ACTIVE_KID, and
+// delete the old file once AccessTokenTTL has passed. Without JWT_KEYS_DIR
+// access tokens fall back to HS256 with TOKEN_SECRET.
+func LoadSigningKeys() error {
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		return nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	keys := map[string]*signingKey{}
+	for _, file := range files {
+		kid := strings.SplitN(filepath.Base(file), ".", 2)[0]
+		key, err := loadSigningKey(kid, file)
+		if err != nil {
+			return fmt.Errorf("failed to load signing key %s: %w", file, err)
+		}
+		keys[kid] = key
+	}
+
+	activeKID := os.Getenv("JWT_ACTIVE_KID")
+	active, ok := keys[activeKID]
+	if !ok || active.Private == nil {
+		return fmt.Errorf("JWT_ACTIVE_KID %q does not name a private key in %s", activeKID, dir)
+	}
+
+	signingKeys = keys
+	activeKey = active
+	return nil
+}
+
+func loadSigningKey(kid, file string) (*signingKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %s", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &signingKey{ID: kid}
+	if signer, ok := parsed.(crypto.Signer); ok {
+		key.Private = signer
+		key.Public = signer.Public()
+	} else {
+		key.Public = parsed
+	}
+
+	switch pub := key.Public.(type) {
+	case *rsa.PublicKey:
+		key.Method = jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			key.Method = jwt.SigningMethodES256
+		case elliptic.P384():
+			key.Method = jwt.SigningMethodES384
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", pub.Curve.Params().Name)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key.Public)
+	}
+
+	return key, nil
+}
+
+// signAccessToken signs claims with the active key, or HS256 if no keyset
+// is configured. MFA challenge tokens are signed the same way.
+func signAccessToken(claims jwt.Claims) (string, error) {
+	if activeKey == nil {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		return token.SignedString([]byte(AccessTokenSecret))
+	}
+
+	token := jwt.NewWithClaims(activeKey.Method, claims)
+	token.Header["kid"] = activeKey.ID
+	return token.SignedString(activeKey.Private)
+}
+
+// accessTokenKey is the jwt.Keyfunc for access tokens. Tokens with a kid are
+// verified with that key only, and the algorithm must match the key.
+func accessTokenKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok || AccessTokenSecret == "" {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(AccessTokenSecret), nil
+	}
+
+	key, ok := signingKeys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %s", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %v for key %s", token.Header["alg"], kid)
+	}
+	return key.Public, nil
+}
+
+// JWKSHandler publishes the public half of every key in the keyset so other
+// services can verify access tokens without sharing a secret.
+func JWKSHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		kids := make([]string, 0, len(signingKeys))
+		for kid := range signingKeys {
+			kids = append(kids, kid)
+		}
+		sort.Strings(kids)
+
+		keys := make([]gin.H, 0, len(kids))
+		for _, kid := range kids {
+			keys = append(keys, publicJWK(signingKeys[kid]))
+		}
+
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, gin.H{"keys": keys})
+	}
+}
+
+func publicJWK(key *signingKey) gin.H {
+	jwk := gin.H{
+		"kid": key.ID,
+		"alg": key.Method.Alg(),
+		"use": "sig",
+	}
+
+	switch pub := key.Public.(type) {
+	case *rsa.PublicKey:
+		jwk["kty"] = "RSA"
+		jwk["n"] = b64url(pub.N.Bytes())
+		jwk["e"] = b64url(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk["kty"] = "EC"
+		jwk["crv"] = pub.Curve.Params().Name
+		jwk["x"] = b64url(pub.X.FillBytes(make([]byte, size)))
+		jwk["y"] = b64url(pub.Y.FillBytes(make([]byte, size)))
+	}
+	return jwk
+}
+
+func b64url(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
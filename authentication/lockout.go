@@ -0,0 +1,119 @@
+package authentication
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"xstudious-guide/store"
+)
+
+// Failed logins are counted per account and per client IP. Once a counter
+// reaches its threshold the key is locked, and every further failure doubles
+// the lockout, up to maxLockout.
+const (
+	attemptWindow       = 24 * time.Hour
+	accountFailureLimit = 5
+	ipFailureLimit      = 20
+	baseLockout         = time.Minute
+	maxLockout          = time.Hour
+)
+
+func accountAttemptKey(email string) string {
+	return "email#" + strings.ToLower(email)
+}
+
+func ipAttemptKey(ip string) string {
+	return "ip#" + ip
+}
+
+// LoginLockedFor returns how long logins for this email or from this IP are
+// still blocked, or zero if they are allowed.
+func LoginLockedFor(attemptStore store.LoginAttemptStore, email, ip string) time.Duration {
+	now := time.Now().Unix()
+	var wait int64
+
+	keys := []string{accountAttemptKey(email)}
+	if ip != "" {
+		keys = append(keys, ipAttemptKey(ip))
+	}
+
+	for _, key := range keys {
+		attempts, err := attemptStore.GetLoginAttempts(key)
+		if err != nil {
+			log.Printf("Failed to read login attempts for %s: %v", key, err)
+			continue
+		}
+		if attempts != nil && attempts.LockedUntil-now > wait {
+			wait = attempts.LockedUntil - now
+		}
+	}
+
+	return time.Duration(wait) * time.Second
+}
+
+// RecordFailedLogin counts a failure against the account and the IP. It
+// reports whether this failure just locked the account, so the owner can be
+// notified once rather than on every attempt.
+func RecordFailedLogin(attemptStore store.LoginAttemptStore, email, ip string) bool {
+	accountLocked := false
+
+	if email != "" {
+		accountLocked = recordFailure(attemptStore, accountAttemptKey(email), accountFailureLimit)
+	}
+	if ip != "" {
+		recordFailure(attemptStore, ipAttemptKey(ip), ipFailureLimit)
+	}
+
+	return accountLocked
+}
+
+func recordFailure(attemptStore store.LoginAttemptStore, key string, limit int) bool {
+	now := time.Now()
+
+	attempts, err := attemptStore.RecordLoginFailure(key, now.Unix(), now.Add(-attemptWindow).Unix())
+	if err != nil {
+		log.Printf("Failed to record login failure for %s: %v", key, err)
+		return false
+	}
+	if attempts.Failures < limit {
+		return false
+	}
+
+	lockout := baseLockout
+	for i := limit; i < attempts.Failures && lockout < maxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > maxLockout {
+		lockout = maxLockout
+	}
+
+	if err := attemptStore.SetLockout(key, now.Add(lockout).Unix()); err != nil {
+		log.Printf("Failed to lock %s: %v", key, err)
+		return false
+	}
+
+	return attempts.Failures == limit
+}
+
+// ClearFailedLogins resets the account counter after a successful login, an
+// admin unlock or an account erasure. IP counters are left alone so a valid login to one account
+// does not reset guessing against others.
+func ClearFailedLogins(attemptStore store.LoginAttemptStore, email string) error {
+	return attemptStore.ClearLoginAttempts(accountAttemptKey(email))
+}
+
+var (
+	dummyPasswordOnce sync.Once
+	dummyPasswordHash string
+)
+
+// CompareDummyPassword spends the same time as a real password check, so a
+// login for an unknown email cannot be told apart by response time. The
+// dummy hash uses the configured scheme, it is created on first use.
+func CompareDummyPassword(password string) {
+	dummyPasswordOnce.Do(func() {
+		dummyPasswordHash, _ = HashedPassword("dummy-password")
+	})
+	CheckPasswordHash(password, dummyPasswordHash)
+}
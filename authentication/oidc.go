@@ -0,0 +1,380 @@
+package authentication
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// OIDCProvider is an OpenID Connect identity provider configured by issuer
+// URL. Endpoints and signing keys are discovered lazily from the issuer, so
+// any compliant issuer works, including a local mock one.
+type OIDCProvider struct {
+	Name         string   `json:"name"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"clientId"`
+	ClientSecret string   `json:"clientSecret"`
+	RedirectURL  string   `json:"redirectUrl"`
+	Scopes       []string `json:"scopes"`
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	keys          map[string]interface{}
+	keysFetchedAt time.Time
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// IDTokenClaims holds the ID token claims we rely on for login and linking.
+type IDTokenClaims struct {
+	Issuer        string       `json:"iss"`
+	Subject       string       `json:"sub"`
+	Audience      oidcAudience `json:"aud"`
+	ExpiresAt     int64        `json:"exp"`
+	IssuedAt      int64        `json:"iat"`
+	Nonce         string       `json:"nonce"`
+	Email         string       `json:"email"`
+	EmailVerified oidcBool     `json:"email_verified"`
+	Name          string       `json:"name"`
+}
+
+func (c IDTokenClaims) Valid() error {
+	now := time.Now().Unix()
+	if c.ExpiresAt == 0 || now > c.ExpiresAt+60 {
+		return fmt.Errorf("id token is expired")
+	}
+	if c.IssuedAt > now+60 {
+		return fmt.Errorf("id token used before issued")
+	}
+	return nil
+}
+
+// oidcAudience accepts both forms of the aud claim: a string or a list.
+type oidcAudience []string
+
+func (a *oidcAudience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = []string{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// oidcBool accepts true and "true", some providers send email_verified as a string.
+type oidcBool bool
+
+func (b *oidcBool) UnmarshalJSON(data []byte) error {
+	*b = oidcBool(strings.Trim(string(data), `"`) == "true")
+	return nil
+}
+
+// oidcKeyRefreshInterval limits how often an unknown kid triggers a JWKS
+// fetch, so tokens with made-up kids cannot hammer the provider.
+const oidcKeyRefreshInterval = time.Minute
+
+var (
+	oidcProviders  = map[string]*OIDCProvider{}
+	oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// LoadOIDCProviders reads OIDC_PROVIDERS, a JSON list of providers, e.g.
+// [{"name":"google","issuer":"https://accounts.google.com","clientId":"...",
+// "clientSecret":"...","redirectUrl":"https://api.example.com/login/oidc/google/callback"}].
+func LoadOIDCProviders() {
+	raw := os.Getenv("OIDC_PROVIDERS")
+	if raw == "" {
+		return
+	}
+
+	var providers []*OIDCProvider
+	if err := json.Unmarshal([]byte(raw), &providers); err != nil {
+		log.Printf("Ignoring OIDC_PROVIDERS: %v", err)
+		return
+	}
+
+	for _, p := range providers {
+		if p.Name == "" || p.Issuer == "" || p.ClientID == "" || p.RedirectURL == "" {
+			log.Printf("Ignoring incomplete OIDC provider %q", p.Name)
+			continue
+		}
+		p.Issuer = strings.TrimRight(p.Issuer, "/")
+		if len(p.Scopes) == 0 {
+			p.Scopes = []string{"openid", "email", "profile"}
+		}
+		oidcProviders[p.Name] = p
+	}
+}
+
+func GetOIDCProvider(name string) (*OIDCProvider, bool) {
+	p, ok := oidcProviders[name]
+	return p, ok
+}
+
+// NewPKCE returns a code verifier and its S256 code challenge (RFC 7636).
+func NewPKCE() (string, string, error) {
+	verifier, err := RandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func (p *OIDCProvider) getDiscovery() (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var d oidcDiscovery
+	if err := getJSON(p.Issuer+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, fmt.Errorf("discovery failed for %s: %w", p.Name, err)
+	}
+	if strings.TrimRight(d.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("discovery issuer %s does not match %s", d.Issuer, p.Issuer)
+	}
+
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+func (p *OIDCProvider) AuthCodeURL(state, nonce, codeChallenge string) (string, error) {
+	d, err := p.getDiscovery()
+	if err != nil {
+		return "", err
+	}
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.ClientID)
+	v.Set("redirect_uri", p.RedirectURL)
+	v.Set("scope", strings.Join(p.Scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", codeChallenge)
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + v.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the verified ID token claims.
+func (p *OIDCProvider) Exchange(code, verifier, nonce string) (*IDTokenClaims, error) {
+	d, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("client_id", p.ClientID)
+	form.Set("code_verifier", verifier)
+	if p.ClientSecret != "" {
+		form.Set("client_secret", p.ClientSecret)
+	}
+
+	resp, err := oidcHTTPClient.PostForm(d.TokenEndpoint, form)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || body.IDToken == "" {
+		return nil, fmt.Errorf("token endpoint returned %d %s", resp.StatusCode, body.Error)
+	}
+
+	return p.VerifyIDToken(body.IDToken, nonce)
+}
+
+func (p *OIDCProvider) VerifyIDToken(rawIDToken, nonce string) (*IDTokenClaims, error) {
+	parsed, err := jwt.ParseWithClaims(rawIDToken, &IDTokenClaims{}, p.idTokenKey)
+	if err != nil || !parsed.Valid {
+		return nil, fmt.Errorf("invalid id token: %w", err)
+	}
+
+	claims := parsed.Claims.(*IDTokenClaims)
+	if strings.TrimRight(claims.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("unexpected issuer %s", claims.Issuer)
+	}
+
+	audienceOK := false
+	for _, aud := range claims.Audience {
+		if aud == p.ClientID {
+			audienceOK = true
+		}
+	}
+	if !audienceOK {
+		return nil, fmt.Errorf("id token not issued for this client")
+	}
+	if claims.Nonce != nonce {
+		return nil, fmt.Errorf("id token nonce mismatch")
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("id token has no subject")
+	}
+
+	return claims, nil
+}
+
+func (p *OIDCProvider) idTokenKey(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+	default:
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	if key := p.cachedKey(kid); key != nil {
+		return key, nil
+	}
+
+	// unknown kid: the provider may have rotated, refetch at most once a minute
+	if !p.claimKeyRefresh() {
+		return nil, fmt.Errorf("no provider key %q", kid)
+	}
+	if err := p.refreshKeys(); err != nil {
+		return nil, err
+	}
+	if key := p.cachedKey(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("no provider key %q", kid)
+}
+
+func (p *OIDCProvider) cachedKey(kid string) interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key
+	}
+	// providers with a single unnamed key
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+	return nil
+}
+
+// claimKeyRefresh reports whether a JWKS fetch may start now and, if so,
+// records it. A failed fetch counts too, a provider that is down is not
+// retried on every login.
+func (p *OIDCProvider) claimKeyRefresh() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if time.Since(p.keysFetchedAt) < oidcKeyRefreshInterval {
+		return false
+	}
+	p.keysFetchedAt = time.Now()
+	return true
+}
+
+func (p *OIDCProvider) refreshKeys() error {
+	d, err := p.getDiscovery()
+	if err != nil {
+		return err
+	}
+
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := getJSON(d.JWKSURI, &set); err != nil {
+		return fmt.Errorf("failed to fetch provider keys: %w", err)
+	}
+
+	keys := map[string]interface{}{}
+	for _, k := range set.Keys {
+		switch k.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			default:
+				continue
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+	return nil
+}
+
+func getJSON(url string, out interface{}) error {
+	resp, err := oidcHTTPClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
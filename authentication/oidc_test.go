@@ -0,0 +1,272 @@
+package authentication
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const mockClientID = "mock-client"
+
+// mockIssuer is a minimal OpenID provider: discovery, JWKS, and a token
+// endpoint that enforces PKCE and echoes the nonce of the authorization
+// request into the ID token.
+type mockIssuer struct {
+	t   *testing.T
+	srv *httptest.Server
+
+	mu          sync.Mutex
+	key         *rsa.PrivateKey
+	kid         string
+	jwksFetches int
+	grants      map[string]mockGrant
+}
+
+type mockGrant struct {
+	challenge string
+	nonce     string
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	m := &mockIssuer{t: t, grants: map[string]mockGrant{}}
+	m.rotate("k1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.srv.URL,
+			"authorization_endpoint": m.srv.URL + "/authorize",
+			"token_endpoint":         m.srv.URL + "/token",
+			"jwks_uri":               m.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.jwksFetches++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": m.kid,
+				"kty": "RSA",
+				"n":   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", m.token)
+	m.srv = httptest.NewServer(mux)
+	t.Cleanup(m.srv.Close)
+
+	return m
+}
+
+// rotate replaces the signing key, the old one disappears from the JWKS.
+func (m *mockIssuer) rotate(kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	m.mu.Lock()
+	m.key, m.kid = key, kid
+	m.mu.Unlock()
+}
+
+func (m *mockIssuer) fetches() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.jwksFetches
+}
+
+// authorize plays the user approving the login at authURL and returns the
+// authorization code the provider would redirect back with.
+func (m *mockIssuer) authorize(authURL string) string {
+	m.t.Helper()
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("response_type") != "code" || q.Get("client_id") != mockClientID || q.Get("code_challenge_method") != "S256" {
+		m.t.Fatalf("unexpected authorization request %s", authURL)
+	}
+
+	code, err := RandomToken(16)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	m.mu.Lock()
+	m.grants[code] = mockGrant{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	m.mu.Unlock()
+	return code
+}
+
+func (m *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	grant, ok := m.grants[r.PostForm.Get("code")]
+	delete(m.grants, r.PostForm.Get("code"))
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	idToken, err := m.sign(m.kid, grant.nonce)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": idToken})
+}
+
+// sign must be called with m.mu held.
+func (m *mockIssuer) sign(kid, nonce string) (string, error) {
+	now := time.Now().Unix()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, IDTokenClaims{
+		Issuer:        m.srv.URL,
+		Subject:       "mock-subject",
+		Audience:      oidcAudience{mockClientID},
+		ExpiresAt:     now + 300,
+		IssuedAt:      now,
+		Nonce:         nonce,
+		Email:         "alice@example.com",
+		EmailVerified: true,
+	})
+	token.Header["kid"] = kid
+	return token.SignedString(m.key)
+}
+
+func (m *mockIssuer) provider() *OIDCProvider {
+	return &OIDCProvider{
+		Name:        "mock",
+		Issuer:      m.srv.URL,
+		ClientID:    mockClientID,
+		RedirectURL: "http://localhost/login/oidc/mock/callback",
+		Scopes:      []string{"openid", "email"},
+	}
+}
+
+// login runs the authorization code flow and returns the code together with
+// the verifier and nonce the server side kept.
+func (m *mockIssuer) login(p *OIDCProvider) (string, string, string) {
+	m.t.Helper()
+
+	verifier, challenge, err := NewPKCE()
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	nonce, err := RandomToken(16)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	authURL, err := p.AuthCodeURL("state", nonce, challenge)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	return m.authorize(authURL), verifier, nonce
+}
+
+func TestOIDCExchange(t *testing.T) {
+	m := newMockIssuer(t)
+	p := m.provider()
+
+	code, verifier, nonce := m.login(p)
+	claims, err := p.Exchange(code, verifier, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "mock-subject" || claims.Email != "alice@example.com" || !claims.EmailVerified {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+
+	// codes work once
+	if _, err := p.Exchange(code, verifier, nonce); err == nil {
+		t.Error("a redeemed code was accepted again")
+	}
+}
+
+func TestOIDCExchangeRequiresPKCEVerifier(t *testing.T) {
+	m := newMockIssuer(t)
+	p := m.provider()
+
+	code, _, nonce := m.login(p)
+	otherVerifier, _, err := NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Exchange(code, otherVerifier, nonce); err == nil {
+		t.Error("exchange succeeded with the wrong code verifier")
+	}
+}
+
+func TestOIDCExchangeRejectsNonceMismatch(t *testing.T) {
+	m := newMockIssuer(t)
+	p := m.provider()
+
+	code, verifier, _ := m.login(p)
+	if _, err := p.Exchange(code, verifier, "nonce-of-another-login"); err == nil {
+		t.Error("id token with a foreign nonce was accepted")
+	}
+}
+
+func TestOIDCKeyRotation(t *testing.T) {
+	m := newMockIssuer(t)
+	p := m.provider()
+
+	code, verifier, nonce := m.login(p)
+	if _, err := p.Exchange(code, verifier, nonce); err != nil {
+		t.Fatal(err)
+	}
+	if m.fetches() != 1 {
+		t.Fatalf("jwks fetched %d times, want 1", m.fetches())
+	}
+
+	// tokens with unknown kids do not refetch within the refresh interval
+	m.mu.Lock()
+	bogus, err := m.sign("bogus", nonce)
+	m.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := p.VerifyIDToken(bogus, nonce); err == nil {
+			t.Fatal("token with an unknown kid was accepted")
+		}
+	}
+	if m.fetches() != 1 {
+		t.Errorf("jwks fetched %d times for unknown kids, want 1", m.fetches())
+	}
+
+	// once the interval has passed, a rotated key is picked up
+	m.rotate("k2")
+	p.mu.Lock()
+	p.keysFetchedAt = time.Now().Add(-oidcKeyRefreshInterval)
+	p.mu.Unlock()
+
+	code, verifier, nonce = m.login(p)
+	if _, err := p.Exchange(code, verifier, nonce); err != nil {
+		t.Fatalf("login after key rotation: %v", err)
+	}
+	if m.fetches() != 2 {
+		t.Errorf("jwks fetched %d times, want 2", m.fetches())
+	}
+}
@@ -0,0 +1,99 @@
+package authentication
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+	"xstudious-guide/amazon"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+const (
+	PurposeVerifyEmail   = "verify_email"
+	PurposePasswordReset = "password_reset"
+	PurposeOIDCState     = "oidc_state"
+	PurposeMagicLogin    = "magic_login"
+	PurposePasskeyCreate = "passkey_register"
+	PurposePasskeyLogin  = "passkey_login"
+	PurposeInvite        = "registration_invite"
+)
+
+// NewOneTimeToken stores a single-use token for the given purpose and returns
+// the raw value to embed in a link. Only its SHA-256 hash is persisted.
+func NewOneTimeToken(client *dynamodb.Client, purpose, userID, email string, ttl time.Duration) (string, error) {
+	return saveOneTimeToken(client, amazon.OneTimeToken{Purpose: purpose, UserID: userID, Email: email}, ttl)
+}
+
+// NewStateToken is a one-time token that is not tied to a user but carries
+// data, e.g. the PKCE verifier of a pending OIDC login.
+func NewStateToken(client *dynamodb.Client, purpose string, data map[string]string, ttl time.Duration) (string, error) {
+	return saveOneTimeToken(client, amazon.OneTimeToken{Purpose: purpose, Data: data}, ttl)
+}
+
+// NewInviteToken stores a registration invite for email. The role to grant
+// and the inviting admin travel in the token's data.
+func NewInviteToken(client *dynamodb.Client, email, role, invitedBy string, ttl time.Duration) (string, error) {
+	return saveOneTimeToken(client, amazon.OneTimeToken{
+		Purpose: PurposeInvite,
+		Email:   strings.ToLower(email),
+		Data:    map[string]string{"role": role, "invitedBy": invitedBy},
+	}, ttl)
+}
+
+// ConsumeInviteToken redeems an invite, but only for the invited address.
+func ConsumeInviteToken(client *dynamodb.Client, raw, email string) (*amazon.OneTimeToken, error) {
+	if raw == "" {
+		return nil, amazon.ErrTokenInvalid
+	}
+	return amazon.ConsumeOneTimeTokenForEmail(client, "tokens", HashToken(raw), PurposeInvite, strings.ToLower(email))
+}
+
+func saveOneTimeToken(client *dynamodb.Client, token amazon.OneTimeToken, ttl time.Duration) (string, error) {
+	raw, err := RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	token.ID = HashToken(raw)
+	token.CreatedAt = now.Unix()
+	token.ExpiresAt = now.Add(ttl).Unix()
+	if err := amazon.SaveOneTimeToken(client, "tokens", token); err != nil {
+		return "", err
+	}
+
+	return raw, nil
+}
+
+func ConsumeOneTimeToken(client *dynamodb.Client, purpose, raw string) (*amazon.OneTimeToken, error) {
+	if raw == "" {
+		return nil, amazon.ErrTokenInvalid
+	}
+	return amazon.ConsumeOneTimeToken(client, "tokens", HashToken(raw), purpose)
+}
+
+// PeekOneTimeToken looks a token up without consuming it, for checks that
+// must not burn the token when they fail.
+func PeekOneTimeToken(client *dynamodb.Client, purpose, raw string) (*amazon.OneTimeToken, error) {
+	if raw == "" {
+		return nil, amazon.ErrTokenInvalid
+	}
+	return amazon.GetOneTimeToken(client, "tokens", HashToken(raw), purpose)
+}
+
+func RandomToken(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func HashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,84 @@
+package authentication
+
+import (
+	"net/http"
+	"xstudious-guide/amazon"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/gin-gonic/gin"
+)
+
+// Per-organization roles. They are separate from the global roles in
+// rbac.go: an org admin manages members of one org, nothing else.
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+func IsOrgRole(role string) bool {
+	return role == OrgRoleOwner || role == OrgRoleAdmin || role == OrgRoleMember
+}
+
+// WithActiveOrg returns a copy of claims switched to the membership's org,
+// or back to the personal space if m is nil.
+func WithActiveOrg(claims UserClaims, m *amazon.Membership) UserClaims {
+	claims.OrgID, claims.OrgRole = "", ""
+	if m != nil {
+		claims.OrgID, claims.OrgRole = m.OrgID, m.Role
+	}
+	return claims
+}
+
+// checkActiveOrg re-reads the membership behind the active org claim, so a
+// removed member loses access to the org's data on their next request. The
+// claim's role is replaced with the current one.
+func checkActiveOrg(client *dynamodb.Client, claims *UserClaims) bool {
+	m, err := amazon.GetMembership(client, "memberships", claims.OrgID, claims.ID)
+	if err != nil || m == nil {
+		return false
+	}
+	claims.OrgRole = m.Role
+	return true
+}
+
+// RequireOrgRole guards /orgs/:id routes. Non-members get a 404 so org IDs
+// cannot be probed; members without one of roles (if given) get a 403. The
+// membership is stored in the context under "membership".
+func RequireOrgRole(client *dynamodb.Client, roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := GetClaims(c)
+		if claims == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing token claims"})
+			c.Abort()
+			return
+		}
+
+		m, err := amazon.GetMembership(client, "memberships", c.Param("id"), claims.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check membership"})
+			c.Abort()
+			return
+		}
+		if m == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+			c.Abort()
+			return
+		}
+
+		if len(roles) > 0 && !containsString(roles, m.Role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Requires organization role " + roles[0]})
+			c.Abort()
+			return
+		}
+
+		c.Set("membership", m)
+		c.Next()
+	}
+}
+
+func GetMembership(c *gin.Context) *amazon.Membership {
+	m, _ := c.Get("membership")
+	membership, _ := m.(*amazon.Membership)
+	return membership
+}
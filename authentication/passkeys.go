@@ -0,0 +1,257 @@
+package authentication
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"xstudious-guide/amazon"
+	"xstudious-guide/store"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// PasskeyCeremonyTTL bounds the time between the begin and finish steps.
+// The WebAuthn session data waits in the tokens table, keyed by the ceremony
+// token handed to the client, so any server instance can finish it.
+const PasskeyCeremonyTTL = 5 * time.Minute
+
+var ErrPasskeyInvalid = errors.New("passkey could not be verified")
+
+var relyingParty *webauthn.WebAuthn
+
+// LoadWebAuthn configures the relying party from WEBAUTHN_RP_ID,
+// WEBAUTHN_RP_NAME and WEBAUTHN_RP_ORIGINS (comma separated). The defaults
+// suit local development.
+func LoadWebAuthn() error {
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		rpID = "localhost"
+	}
+	rpName := os.Getenv("WEBAUTHN_RP_NAME")
+	if rpName == "" {
+		rpName = "xstudious-guide"
+	}
+
+	var origins []string
+	for _, origin := range strings.Split(os.Getenv("WEBAUTHN_RP_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	if len(origins) == 0 {
+		origins = []string{"http://localhost:8080"}
+	}
+
+	rp, err := webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: rpName,
+		RPOrigins:     origins,
+	})
+	if err != nil {
+		return fmt.Errorf("invalid WebAuthn configuration: %w", err)
+	}
+
+	relyingParty = rp
+	return nil
+}
+
+// passkeyUser adapts a user and their stored passkeys to webauthn.User.
+type passkeyUser struct {
+	user     amazon.User
+	passkeys []amazon.Passkey
+	creds    []webauthn.Credential
+}
+
+func (u *passkeyUser) WebAuthnID() []byte                         { return []byte(u.user.ID) }
+func (u *passkeyUser) WebAuthnName() string                       { return u.user.Email }
+func (u *passkeyUser) WebAuthnDisplayName() string                { return u.user.Name }
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential { return u.creds }
+
+func loadPasskeyUser(client *dynamodb.Client, user amazon.User) (*passkeyUser, error) {
+	passkeys, err := amazon.GetUserPasskeys(client, "passkeys", user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	pu := &passkeyUser{user: user, passkeys: passkeys}
+	for _, p := range passkeys {
+		var cred webauthn.Credential
+		if err := json.Unmarshal([]byte(p.Credential), &cred); err != nil {
+			return nil, fmt.Errorf("corrupt passkey %s: %w", p.ID, err)
+		}
+		pu.creds = append(pu.creds, cred)
+	}
+	return pu, nil
+}
+
+func saveCeremony(client *dynamodb.Client, purpose string, session *webauthn.SessionData, data map[string]string) (string, error) {
+	raw, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+	data["session"] = string(raw)
+	return NewStateToken(client, purpose, data, PasskeyCeremonyTTL)
+}
+
+func loadCeremony(client *dynamodb.Client, purpose, ceremony string) (*amazon.OneTimeToken, webauthn.SessionData, error) {
+	var session webauthn.SessionData
+
+	token, err := ConsumeOneTimeToken(client, purpose, ceremony)
+	if err != nil {
+		return nil, session, err
+	}
+	if err := json.Unmarshal([]byte(token.Data["session"]), &session); err != nil {
+		return nil, session, err
+	}
+	return token, session, nil
+}
+
+// BeginPasskeyRegistration returns the options for navigator.credentials.create
+// and the ceremony token to send back with the result. Passkeys the user
+// already has are excluded so the same authenticator is not registered twice.
+func BeginPasskeyRegistration(client *dynamodb.Client, user amazon.User, name string) (*protocol.CredentialCreation, string, error) {
+	pu, err := loadPasskeyUser(client, user)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var exclude []protocol.CredentialDescriptor
+	for _, cred := range pu.creds {
+		exclude = append(exclude, cred.Descriptor())
+	}
+
+	creation, session, err := relyingParty.BeginRegistration(pu,
+		webauthn.WithExclusions(exclude),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ceremony, err := saveCeremony(client, PurposePasskeyCreate, session, map[string]string{
+		"userId": user.ID,
+		"name":   name,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return creation, ceremony, nil
+}
+
+// FinishPasskeyRegistration verifies the attestation and stores the new passkey.
+func FinishPasskeyRegistration(client *dynamodb.Client, user amazon.User, ceremony string, response []byte) (*amazon.Passkey, error) {
+	token, session, err := loadCeremony(client, PurposePasskeyCreate, ceremony)
+	if err != nil {
+		return nil, err
+	}
+	if token.Data["userId"] != user.ID {
+		return nil, amazon.ErrTokenInvalid
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(response)
+	if err != nil {
+		return nil, ErrPasskeyInvalid
+	}
+
+	pu, err := loadPasskeyUser(client, user)
+	if err != nil {
+		return nil, err
+	}
+
+	cred, err := relyingParty.CreateCredential(pu, session, parsed)
+	if err != nil {
+		return nil, ErrPasskeyInvalid
+	}
+
+	raw, err := json.Marshal(cred)
+	if err != nil {
+		return nil, err
+	}
+
+	name := token.Data["name"]
+	if name == "" {
+		name = fmt.Sprintf("Passkey %d", len(pu.passkeys)+1)
+	}
+
+	passkey := amazon.Passkey{
+		UserID:     user.ID,
+		ID:         base64.RawURLEncoding.EncodeToString(cred.ID),
+		Name:       name,
+		Credential: string(raw),
+		CreatedAt:  time.Now().Unix(),
+	}
+	if err := amazon.CreatePasskey(client, "passkeys", passkey); err != nil {
+		return nil, err
+	}
+	return &passkey, nil
+}
+
+// BeginPasskeyLogin starts a discoverable login: the browser offers the
+// user's passkeys for this site, no email has to be typed in first.
+func BeginPasskeyLogin(client *dynamodb.Client) (*protocol.CredentialAssertion, string, error) {
+	assertion, session, err := relyingParty.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ceremony, err := saveCeremony(client, PurposePasskeyLogin, session, map[string]string{})
+	if err != nil {
+		return nil, "", err
+	}
+	return assertion, ceremony, nil
+}
+
+// FinishPasskeyLogin verifies the assertion and returns the signed-in user.
+// User verification is required, so a passkey counts as both factors.
+func FinishPasskeyLogin(client *dynamodb.Client, users store.UserStore, ceremony string, response []byte) (*amazon.User, error) {
+	_, session, err := loadCeremony(client, PurposePasskeyLogin, ceremony)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return nil, ErrPasskeyInvalid
+	}
+
+	var pu *passkeyUser
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		user, err := users.GetUser(string(userHandle))
+		if err != nil {
+			return nil, err
+		}
+		pu, err = loadPasskeyUser(client, *user)
+		if err != nil {
+			return nil, err
+		}
+		return pu, nil
+	}
+
+	cred, err := relyingParty.ValidateDiscoverableLogin(handler, session, parsed)
+	if err != nil || pu == nil {
+		return nil, ErrPasskeyInvalid
+	}
+	// a signature counter going backwards means the key may have been cloned
+	if cred.Authenticator.CloneWarning {
+		return nil, ErrPasskeyInvalid
+	}
+
+	raw, err := json.Marshal(cred)
+	if err != nil {
+		return nil, err
+	}
+	id := base64.RawURLEncoding.EncodeToString(cred.ID)
+	if err := amazon.UpdatePasskeyUsage(client, "passkeys", pu.user.ID, id, string(raw), time.Now().Unix()); err != nil {
+		return nil, err
+	}
+
+	return &pu.user, nil
+}
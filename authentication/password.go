@@ -0,0 +1,295 @@
+package authentication
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordPolicy is read from the environment by LoadPasswordPolicy:
+// PASSWORD_MIN_LENGTH (default 10), PASSWORD_MAX_LENGTH (default 128),
+// PASSWORD_MIN_CLASSES, how many of lowercase, uppercase, digits and symbols
+// must appear (default 0), and BREACHED_PASSWORDS_FILE.
+type PasswordPolicy struct {
+	MinLength  int
+	MaxLength  int
+	MinClasses int
+}
+
+// PasswordPolicyError lists every rule a password breaks, so clients can show
+// them all at once.
+type PasswordPolicyError struct {
+	Problems []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password does not meet the policy: " + strings.Join(e.Problems, "; ")
+}
+
+// Password hashing is configured with PASSWORD_HASH (argon2id, the default,
+// or bcrypt) and BCRYPT_COST. Stored hashes made with another scheme or
+// weaker parameters are replaced at the next successful login.
+const (
+	hashArgon2id = "argon2id"
+	hashBcrypt   = "bcrypt"
+
+	argonMemory  = 19 * 1024 // KiB, the OWASP baseline for argon2id
+	argonTime    = 2
+	argonThreads = 1
+	argonKeyLen  = 32
+	argonSaltLen = 16
+)
+
+var (
+	passwordPolicy = PasswordPolicy{MinLength: 10, MaxLength: 128}
+	passwordHash   = hashArgon2id
+	bcryptCost     = 12
+
+	// breachedPasswords maps the first 5 hex digits of a SHA-1 hash to the
+	// remaining 35, the same k-anonymity split the Pwned Passwords range API uses.
+	breachedPasswords = map[string]map[string]struct{}{}
+)
+
+func LoadPasswordPolicy() error {
+	if err := envInt("PASSWORD_MIN_LENGTH", &passwordPolicy.MinLength); err != nil {
+		return err
+	}
+	if err := envInt("PASSWORD_MAX_LENGTH", &passwordPolicy.MaxLength); err != nil {
+		return err
+	}
+	if err := envInt("PASSWORD_MIN_CLASSES", &passwordPolicy.MinClasses); err != nil {
+		return err
+	}
+	if err := envInt("BCRYPT_COST", &bcryptCost); err != nil {
+		return err
+	}
+
+	switch scheme := os.Getenv("PASSWORD_HASH"); scheme {
+	case "":
+	case hashArgon2id, hashBcrypt:
+		passwordHash = scheme
+	default:
+		return fmt.Errorf("unknown PASSWORD_HASH %q", scheme)
+	}
+
+	// bcrypt ignores everything past 72 bytes
+	if passwordHash == hashBcrypt && passwordPolicy.MaxLength > 72 {
+		passwordPolicy.MaxLength = 72
+	}
+
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		return loadBreachedPasswords(path)
+	}
+	return nil
+}
+
+func envInt(name string, target *int) error {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	*target = n
+	return nil
+}
+
+// loadBreachedPasswords reads a file in the Pwned Passwords download format,
+// one upper case SHA-1 hash per line with an optional ":count" suffix.
+func loadBreachedPasswords(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open breached passwords file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		hash, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if len(hash) != 40 {
+			continue
+		}
+		hash = strings.ToUpper(hash)
+
+		prefix, suffix := hash[:5], hash[5:]
+		if breachedPasswords[prefix] == nil {
+			breachedPasswords[prefix] = map[string]struct{}{}
+		}
+		breachedPasswords[prefix][suffix] = struct{}{}
+	}
+	return scanner.Err()
+}
+
+func IsBreachedPassword(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	_, found := breachedPasswords[hash[:5]][hash[5:]]
+	return found
+}
+
+// ValidatePassword checks a new password against the policy. name and email
+// are the account's, a password containing either is rejected.
+func ValidatePassword(password, name, email string) error {
+	var problems []string
+
+	length := len([]rune(password))
+	if length < passwordPolicy.MinLength {
+		problems = append(problems, fmt.Sprintf("must be at least %d characters long", passwordPolicy.MinLength))
+	}
+	if length > passwordPolicy.MaxLength || (passwordHash == hashBcrypt && len(password) > 72) {
+		problems = append(problems, fmt.Sprintf("must be at most %d characters long", passwordPolicy.MaxLength))
+	}
+
+	if classes := characterClasses(password); classes < passwordPolicy.MinClasses {
+		problems = append(problems, fmt.Sprintf("must mix at least %d of lowercase letters, uppercase letters, digits and symbols", passwordPolicy.MinClasses))
+	}
+
+	if containsPersonalInfo(password, name, email) {
+		problems = append(problems, "must not contain your name or email address")
+	}
+
+	if IsBreachedPassword(password) {
+		problems = append(problems, "has appeared in a data breach, choose a different one")
+	}
+
+	if len(problems) > 0 {
+		return &PasswordPolicyError{Problems: problems}
+	}
+	return nil
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
+
+// containsPersonalInfo ignores parts shorter than 3 characters, which would
+// reject too many good passwords.
+func containsPersonalInfo(password, name, email string) bool {
+	password = strings.ToLower(password)
+
+	parts := strings.Fields(strings.ToLower(name))
+	if local, _, ok := strings.Cut(strings.ToLower(email), "@"); ok {
+		parts = append(parts, local)
+	}
+
+	for _, part := range parts {
+		if len(part) >= 3 && strings.Contains(password, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// HashedPassword hashes with the configured scheme. argon2id hashes use the
+// PHC string format: $argon2id$v=19$m=...,t=...,p=...$salt$hash.
+func HashedPassword(password string) (string, error) {
+	if passwordHash == hashBcrypt {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+		return string(hashed), err
+	}
+
+	salt := make([]byte, argonSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argonMemory, argonTime, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func CheckPasswordHash(password, hash string) bool {
+	if password == "" || hash == "" {
+		return false
+	}
+
+	if strings.HasPrefix(hash, "$argon2id$") {
+		params, salt, key, err := parseArgon2Hash(hash)
+		if err != nil {
+			return false
+		}
+		candidate := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, uint32(len(key)))
+		return subtle.ConstantTimeCompare(candidate, key) == 1
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	return err == nil
+}
+
+// NeedsRehash reports whether a stored hash was made with a different scheme
+// or weaker parameters than are configured now.
+func NeedsRehash(hash string) bool {
+	if passwordHash == hashBcrypt {
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost < bcryptCost
+	}
+
+	params, _, _, err := parseArgon2Hash(hash)
+	if err != nil {
+		return true
+	}
+	return params.memory < argonMemory || params.time < argonTime || params.threads < argonThreads
+}
+
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+func parseArgon2Hash(hash string) (argon2Params, []byte, []byte, error) {
+	var params argon2Params
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != hashArgon2id {
+		return params, nil, nil, fmt.Errorf("not an argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2 parameters: %w", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, fmt.Errorf("invalid argon2 hash")
+	}
+	return params, salt, key, nil
+}
@@ -0,0 +1,283 @@
+package authentication
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Permissions double as OAuth-style token scopes: roles decide what a
+// principal may do, the scopes in a token limit what that token may do.
+const (
+	PermUsersRead   = "users:read"
+	PermUsersWrite  = "users:write"
+	PermUsersDelete = "users:delete"
+	PermUsersAdmin  = "users:admin" // act on users other than yourself
+	PermFilesRead   = "files:read"
+	PermFilesWrite  = "files:write"
+	PermFilesAdmin  = "files:admin" // read files owned by other users
+	PermEmailSend   = "email:send"
+	PermAIPrompt    = "ai:prompt"
+	PermMapsRead    = "maps:read"
+)
+
+var rolePermissions = map[string][]string{
+	RoleUser: {
+		PermUsersRead, PermUsersWrite, PermUsersDelete,
+		PermFilesRead, PermFilesWrite,
+		PermEmailSend, PermAIPrompt, PermMapsRead,
+	},
+	RoleAdmin: {
+		PermUsersRead, PermUsersWrite, PermUsersDelete, PermUsersAdmin,
+		PermFilesRead, PermFilesWrite, PermFilesAdmin,
+		PermEmailSend, PermAIPrompt, PermMapsRead,
+	},
+}
+
+// LoadCustomRoles reads additional roles from RBAC_CUSTOM_ROLES, a JSON object
+// mapping role names to permission lists, e.g. {"support":["users:read","users:admin"]}.
+// The built-in user and admin roles cannot be overridden.
+func LoadCustomRoles() {
+	raw := os.Getenv("RBAC_CUSTOM_ROLES")
+	if raw == "" {
+		return
+	}
+
+	var custom map[string][]string
+	if err := json.Unmarshal([]byte(raw), &custom); err != nil {
+		log.Printf("Ignoring RBAC_CUSTOM_ROLES: %v", err)
+		return
+	}
+
+	for role, perms := range custom {
+		if role == RoleUser || role == RoleAdmin {
+			log.Printf("Ignoring custom definition of built-in role %s", role)
+			continue
+		}
+		rolePermissions[role] = perms
+	}
+}
+
+func IsKnownRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// DefaultRoles returns the roles given to a newly registered account. Emails
+// listed in ADMIN_EMAILS are bootstrapped as admins.
+func DefaultRoles(email string) []string {
+	for _, admin := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		if admin = strings.TrimSpace(admin); admin != "" && strings.EqualFold(admin, email) {
+			return []string{RoleUser, RoleAdmin}
+		}
+	}
+	return []string{RoleUser}
+}
+
+// HasPermission reports whether the caller's roles grant perm and the token
+// used for this request is scoped for it.
+func HasPermission(claims *UserClaims, perm string) bool {
+	return HasRolePermission(claims, perm) && HasScope(claims, perm)
+}
+
+func HasRolePermission(claims *UserClaims, perm string) bool {
+	if claims == nil {
+		return false
+	}
+
+	// service clients have no roles, they hold exactly their scopes
+	if claims.IsClient() {
+		return containsString(claims.Scopes, perm)
+	}
+
+	roles := claims.Roles
+	if len(roles) == 0 {
+		// tokens issued before roles existed belong to regular users
+		roles = []string{RoleUser}
+	}
+
+	for _, role := range roles {
+		if containsString(rolePermissions[role], perm) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope reports whether the token carries scope. A token without scopes
+// holds none, full sessions carry every permission of their roles instead.
+func HasScope(claims *UserClaims, scope string) bool {
+	if claims == nil {
+		return false
+	}
+	return containsString(claims.Scopes, scope)
+}
+
+// PermissionsForRoles lists every permission granted by the given roles, the
+// full scope set NewUserClaims gives a token issued at login.
+func PermissionsForRoles(roles []string) []string {
+	if len(roles) == 0 {
+		roles = []string{RoleUser}
+	}
+
+	var perms []string
+	for _, role := range roles {
+		for _, perm := range rolePermissions[role] {
+			if !containsString(perms, perm) {
+				perms = append(perms, perm)
+			}
+		}
+	}
+	return perms
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// CanActOnUser reports whether the caller may touch resources owned by
+// ownerID: either they are the owner or they hold adminPerm.
+func CanActOnUser(claims *UserClaims, ownerID, adminPerm string) bool {
+	if claims == nil {
+		return false
+	}
+	return claims.ID == ownerID || HasPermission(claims, adminPerm)
+}
+
+func GetClaims(c *gin.Context) *UserClaims {
+	claims, ok := c.Get("claims")
+	if !ok {
+		return nil
+	}
+	userClaims, _ := claims.(*UserClaims)
+	return userClaims
+}
+
+// RequirePermission must run after AuthMiddleware. The request is rejected
+// unless the caller's roles grant every listed permission. Token scopes are
+// checked separately by RequireScope.
+func RequirePermission(perms ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := GetClaims(c)
+		if claims == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing token claims"})
+			c.Abort()
+			return
+		}
+
+		for _, perm := range perms {
+			if !HasRolePermission(claims, perm) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Missing permission " + perm})
+				c.Abort()
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// RequireVerifiedEmail blocks callers whose email is unverified when the
+// REQUIRE_VERIFIED_EMAIL policy is enabled, and is a no-op otherwise.
+func RequireVerifiedEmail() gin.HandlerFunc {
+	enforce := os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true"
+
+	return func(c *gin.Context) {
+		if !enforce {
+			c.Next()
+			return
+		}
+
+		// service clients have no email address to verify
+		claims := GetClaims(c)
+		if claims == nil || (!claims.Verified && !claims.IsClient()) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Email address must be verified"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// DenyAPIKeys rejects requests authenticated with an API key, for routes such
+// as key management that need an interactive login.
+func DenyAPIKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if claims := GetClaims(c); claims != nil && claims.APIKeyID != "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API keys cannot be used here"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireScope is meant for route groups: it rejects tokens that lack any of
+// the listed scopes with a 403 and an RFC 6750 style error body.
+func RequireScope(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := GetClaims(c)
+		if claims == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing token claims"})
+			c.Abort()
+			return
+		}
+
+		for _, scope := range scopes {
+			if !HasScope(claims, scope) {
+				c.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+strings.Join(scopes, " ")+`"`)
+				c.JSON(http.StatusForbidden, gin.H{
+					"error":           "insufficient_scope",
+					"message":         "Token is missing a required scope",
+					"required_scopes": scopes,
+					"token_scopes":    claims.Scopes,
+				})
+				c.Abort()
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// NewScopedToken mints an access token for the same principal as parent but
+// limited to scopes, which must all be held by parent. No refresh token is
+// issued, the token simply expires.
+func NewScopedToken(parent *UserClaims, scopes []string, ttl time.Duration, purpose string) (string, error) {
+	for _, scope := range scopes {
+		if !HasPermission(parent, scope) {
+			return "", fmt.Errorf("scope %s is not held by the caller", scope)
+		}
+	}
+
+	claims := *parent
+	claims.Scopes = scopes
+	claims.Purpose = purpose
+	claims.IssuedAt = time.Now().Unix()
+	claims.ExpiresAt = time.Now().Add(ttl).Unix()
+
+	// never outlive the token it was derived from
+	if parent.ExpiresAt != 0 && claims.ExpiresAt > parent.ExpiresAt {
+		claims.ExpiresAt = parent.ExpiresAt
+	}
+
+	return NewAccessToken(claims)
+}
@@ -0,0 +1,83 @@
+package authentication
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+)
+
+// ErasureReceiptSecret signs erasure receipts. Receipts must stay verifiable
+// for as long as anyone may ask about an erasure, so unlike the access token
+// keys this secret is never rotated away.
+var ErasureReceiptSecret string
+
+// The typ header and audience keep receipts apart from access tokens.
+const (
+	erasureReceiptType     = "erasure-receipt+jwt"
+	erasureReceiptAudience = "erasure-receipt"
+)
+
+// ErasureReceipt is the signed proof that a user's data was erased. The user
+// ID and an unsalted hash of the email let the user show which account it
+// was for; both still identify them, so the receipt is only handed to the
+// user and never stored.
+type ErasureReceipt struct {
+	EmailHash string   `json:"email_sha256"`
+	Erased    []string `json:"erased"`
+	Retained  []string `json:"retained,omitempty"`
+	TokenType string   `json:"token_type"`
+	jwt.StandardClaims
+}
+
+// NewErasureReceipt signs a receipt with ErasureReceiptSecret. It has no
+// expiry, and can be checked with ParseErasureReceipt for as long as the
+// secret is kept.
+func NewErasureReceipt(userID, email string, erased, retained []string) (string, *ErasureReceipt, error) {
+	sum := sha256.Sum256([]byte(strings.ToLower(email)))
+	receipt := &ErasureReceipt{
+		EmailHash: hex.EncodeToString(sum[:]),
+		Erased:    erased,
+		Retained:  retained,
+		TokenType: "erasure_receipt",
+		StandardClaims: jwt.StandardClaims{
+			Audience: erasureReceiptAudience,
+			Id:       uuid.NewString(),
+			IssuedAt: time.Now().Unix(),
+			Subject:  userID,
+		},
+	}
+
+	if ErasureReceiptSecret == "" {
+		return "", nil, fmt.Errorf("ERASURE_RECEIPT_SECRET is not set")
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, receipt)
+	token.Header["typ"] = erasureReceiptType
+	signed, err := token.SignedString([]byte(ErasureReceiptSecret))
+	if err != nil {
+		return "", nil, err
+	}
+	return signed, receipt, nil
+}
+
+func ParseErasureReceipt(raw string) *ErasureReceipt {
+	parsed, err := jwt.ParseWithClaims(raw, &ErasureReceipt{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok || ErasureReceiptSecret == "" {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(ErasureReceiptSecret), nil
+	})
+	if err != nil || !parsed.Valid || parsed.Header["typ"] != erasureReceiptType {
+		return nil
+	}
+
+	receipt, ok := parsed.Claims.(*ErasureReceipt)
+	if !ok || receipt.TokenType != "erasure_receipt" || !receipt.VerifyAudience(erasureReceiptAudience, true) {
+		return nil
+	}
+	return receipt
+}
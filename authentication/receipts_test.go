@@ -0,0 +1,49 @@
+package authentication
+
+import (
+	"testing"
+	"xstudious-guide/amazon"
+)
+
+func TestErasureReceiptsAndAccessTokensDoNotMix(t *testing.T) {
+	AccessTokenSecret = "test-access-secret"
+	ErasureReceiptSecret = "test-receipt-secret"
+
+	receipt, _, err := NewErasureReceipt("u_alice", "alice@example.com", []string{"profile"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ParseErasureReceipt(receipt) == nil {
+		t.Fatal("receipt does not verify")
+	}
+	if ParseAccessToken(receipt) != nil {
+		t.Error("receipt was accepted as an access token")
+	}
+
+	access, err := NewAccessToken(NewUserClaims(amazon.User{ID: "u_alice", Email: "alice@example.com"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ParseAccessToken(access) == nil {
+		t.Fatal("access token does not verify")
+	}
+	if ParseErasureReceipt(access) != nil {
+		t.Error("access token was accepted as a receipt")
+	}
+}
+
+func TestErasureReceiptsOutliveAccessTokenKeys(t *testing.T) {
+	AccessTokenSecret = "test-access-secret"
+	ErasureReceiptSecret = "test-receipt-secret"
+
+	receipt, _, err := NewErasureReceipt("u_alice", "alice@example.com", []string{"profile"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	AccessTokenSecret = "rotated-access-secret"
+	t.Cleanup(func() { AccessTokenSecret = "test-access-secret" })
+	if ParseErasureReceipt(receipt) == nil {
+		t.Error("receipt stopped verifying after the access token secret changed")
+	}
+}
@@ -0,0 +1,167 @@
+package authentication
+
+import (
+	"log"
+	"net/http"
+	"time"
+	"xstudious-guide/amazon"
+	"xstudious-guide/store"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+)
+
+func NewUserClaims(user amazon.User) UserClaims {
+	return UserClaims{
+		ID:          user.ID,
+		Name:        user.Name,
+		Email:       user.Email,
+		Roles:       user.Roles,
+		Verified:    user.IsEmailVerified(),
+		Scopes:      PermissionsForRoles(user.Roles),
+		SubjectType: SubjectUser,
+		TokenType:   "access",
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(AccessTokenTTL).Unix(),
+			IssuedAt:  time.Now().Unix(),
+			Subject:   user.ID,
+		},
+	}
+}
+
+// NewTokenPair starts a new server-side session for the user and returns an
+// access token together with the first refresh token of that session. The
+// access token carries the session ID, so revoking the session cuts it off.
+func NewTokenPair(sessions store.SessionStore, user amazon.User, userAgent, ip string) (string, string, error) {
+	now := time.Now()
+	session := amazon.Session{
+		ID:         uuid.NewString(),
+		UserID:     user.ID,
+		TokenID:    uuid.NewString(),
+		UserAgent:  truncateUserAgent(userAgent),
+		IP:         ip,
+		CreatedAt:  now.Unix(),
+		LastSeenAt: now.Unix(),
+		ExpiresAt:  now.Add(RefreshTokenTTL).Unix(),
+	}
+	if err := sessions.CreateSession(session); err != nil {
+		return "", "", err
+	}
+
+	// a new session starts in the user's personal space
+	claims := NewUserClaims(user)
+	claims.SessionID = session.ID
+	accessToken, err := NewAccessToken(claims)
+	if err != nil {
+		return "", "", err
+	}
+
+	refreshToken, err := newSessionRefreshToken(session)
+	if err != nil {
+		return "", "", err
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+// NewSessionAccessToken issues an access token for a session, in the
+// session's active organization as long as the user is still a member.
+func NewSessionAccessToken(client *dynamodb.Client, user amazon.User, session amazon.Session) (string, error) {
+	claims := NewUserClaims(user)
+	claims.SessionID = session.ID
+
+	if session.OrgID != "" {
+		m, err := amazon.GetMembership(client, "memberships", session.OrgID, user.ID)
+		if err != nil {
+			return "", err
+		}
+		claims = WithActiveOrg(claims, m)
+	}
+
+	return NewAccessToken(claims)
+}
+
+func rotateRefreshToken(sessions store.SessionStore, session *amazon.Session, oldTokenID, userAgent, ip string) (string, error) {
+	now := time.Now()
+	next := *session
+	next.TokenID = uuid.NewString()
+	next.UserAgent = truncateUserAgent(userAgent)
+	next.IP = ip
+	next.LastSeenAt = now.Unix()
+	next.ExpiresAt = now.Add(RefreshTokenTTL).Unix()
+
+	if err := sessions.RotateSession(oldTokenID, next); err != nil {
+		return "", err
+	}
+	return newSessionRefreshToken(next)
+}
+
+func truncateUserAgent(userAgent string) string {
+	if len(userAgent) > 256 {
+		return userAgent[:256]
+	}
+	return userAgent
+}
+
+func newSessionRefreshToken(session amazon.Session) (string, error) {
+	return NewRefreshToken(RefreshClaims{
+		SessionID: session.ID,
+		StandardClaims: jwt.StandardClaims{
+			Id:        session.TokenID,
+			ExpiresAt: session.ExpiresAt,
+			IssuedAt:  time.Now().Unix(),
+			Subject:   session.UserID,
+		},
+	})
+}
+
+func revokeReusedSession(sessions store.SessionStore, sessionID string) {
+	log.Printf("Refresh token reuse detected for session %s, revoking", sessionID)
+	if err := sessions.RevokeSession(sessionID); err != nil {
+		log.Printf("Failed to revoke session %s: %v", sessionID, err)
+	}
+}
+
+func LogoutHandler(sessions store.SessionStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req RefreshRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		claims := ParseRefreshToken(req.RefreshToken)
+		if claims == nil || claims.SessionID == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+			return
+		}
+
+		session, err := sessions.GetSession(claims.SessionID)
+		if err != nil || session.UserID != claims.Subject {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session is no longer valid"})
+			return
+		}
+
+		if err := sessions.RevokeSession(session.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+	}
+}
+
+func LogoutAllHandler(sessions store.SessionStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := c.MustGet("claims").(*UserClaims)
+
+		if err := sessions.RevokeUserSessions(claims.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Logged out of all sessions"})
+	}
+}
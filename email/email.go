@@ -32,6 +32,9 @@ func InitEmail() (*resend.Client, string) {
 }
 
 func SendEmail(client *resend.Client, email EmailRequest) error {
+	if client == nil {
+		return fmt.Errorf("email client is not configured")
+	}
 
 	sender := fmt.Sprintf("%s <%s>", email.Alias, email.Sender)
 
@@ -44,7 +47,7 @@ func SendEmail(client *resend.Client, email EmailRequest) error {
 
 	sent, err := client.Emails.Send(params)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("failed to send email: %w", err)
 	}
 	fmt.Println(sent.Id)
 	return nil
@@ -0,0 +1,98 @@
+package email
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"time"
+)
+
+// systemEmail builds a transactional email from the server's own sender,
+// configured with EMAIL_ALIAS and EMAIL_SENDER.
+func systemEmail(to, subject, body string) EmailRequest {
+	alias := os.Getenv("EMAIL_ALIAS")
+	if alias == "" {
+		alias = "xstudious-guide"
+	}
+
+	return EmailRequest{
+		Alias:      alias,
+		Sender:     os.Getenv("EMAIL_SENDER"),
+		Recipients: []string{to},
+		Subject:    subject,
+		Html:       body,
+	}
+}
+
+// AppURL joins path onto APP_BASE_URL, the public address links in emails point at.
+func AppURL(path string) string {
+	base := os.Getenv("APP_BASE_URL")
+	if base == "" {
+		base = "http://localhost:8080"
+	}
+	return base + path
+}
+
+func VerificationEmail(to, name, link string) EmailRequest {
+	body := fmt.Sprintf(
+		`<p>Hi %s,</p><p>Please confirm your email address by following <a href="%s">this link</a>.</p><p>The link expires in 24 hours and can only be used once.</p>`,
+		html.EscapeString(name), html.EscapeString(link),
+	)
+	return systemEmail(to, "Verify your email address", body)
+}
+
+func PasswordResetEmail(to, name, link string) EmailRequest {
+	body := fmt.Sprintf(
+		`<p>Hi %s,</p><p>Someone asked to reset the password for your account. If that was you, follow <a href="%s">this link</a> to choose a new one.</p><p>The link expires in 30 minutes and can only be used once. If you did not ask for this, you can ignore this email.</p>`,
+		html.EscapeString(name), html.EscapeString(link),
+	)
+	return systemEmail(to, "Reset your password", body)
+}
+
+func LockoutEmail(to, name string, until time.Time) EmailRequest {
+	body := fmt.Sprintf(
+		`<p>Hi %s,</p><p>There were several failed attempts to sign in to your account, so sign-in has been paused until %s.</p><p>If this was not you, consider resetting your password. An administrator can also unlock your account.</p>`,
+		html.EscapeString(name), until.UTC().Format(time.RFC1123),
+	)
+	return systemEmail(to, "Sign-in to your account was paused", body)
+}
+
+func MagicLinkEmail(to, name, link string) EmailRequest {
+	body := fmt.Sprintf(
+		`<p>Hi %s,</p><p>Follow <a href="%s">this link</a> to sign in to your account.</p><p>The link expires in 15 minutes and can only be used once. If you did not ask to sign in, you can ignore this email.</p>`,
+		html.EscapeString(name), html.EscapeString(link),
+	)
+	return systemEmail(to, "Your sign-in link", body)
+}
+
+func OrgInvitationEmail(to, orgName, inviterName, link string) EmailRequest {
+	body := fmt.Sprintf(
+		`<p>Hi,</p><p>%s invited you to join <strong>%s</strong>. <a href="%s">Sign in</a> with this email address to accept or decline.</p><p>The invitation expires in 7 days.</p>`,
+		html.EscapeString(inviterName), html.EscapeString(orgName), html.EscapeString(link),
+	)
+	return systemEmail(to, "You have been invited to "+orgName, body)
+}
+
+func InviteEmail(to, inviterName, link string, expires time.Time) EmailRequest {
+	body := fmt.Sprintf(
+		`<p>Hi,</p><p>%s invited you to create an account. Follow <a href="%s">this link</a> to sign up with this email address.</p><p>The invitation expires on %s and can only be used once.</p>`,
+		html.EscapeString(inviterName), html.EscapeString(link), expires.UTC().Format(time.RFC1123),
+	)
+	return systemEmail(to, "You have been invited", body)
+}
+
+func DataExportEmail(to, name, link string, expires time.Time) EmailRequest {
+	body := fmt.Sprintf(
+		`<p>Hi %s,</p><p>The export of your data is ready. Follow <a href="%s">this link</a> to download it.</p><p>The link expires on %s. If you did not ask for an export, please change your password.</p>`,
+		html.EscapeString(name), html.EscapeString(link), expires.UTC().Format(time.RFC1123),
+	)
+	return systemEmail(to, "Your data export is ready", body)
+}
+
+func ErasureReceiptEmail(to, name, receiptID, receipt string) EmailRequest {
+	body := fmt.Sprintf(
+		`<p>Hi %s,</p><p>Your account and the data we held about you have been erased. This is the last email you will receive from us.</p><p>Keep the signed receipt below as proof of the erasure, reference %s:</p><pre style="white-space: pre-wrap; word-break: break-all">%s</pre>`,
+		html.EscapeString(name), html.EscapeString(receiptID), html.EscapeString(receipt),
+	)
+	return systemEmail(to, "Your account has been erased", body)
+}
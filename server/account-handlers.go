@@ -0,0 +1,130 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"xstudious-guide/amazon"
+	"xstudious-guide/authentication"
+	"xstudious-guide/store"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/gin-gonic/gin"
+)
+
+// DeactivateUserReq blocks an account until an admin reactivates it. Nothing
+// is scheduled for deletion.
+func DeactivateUserReq(stores store.Stores, client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := findAccount(c, stores.Users, c.Param("id"))
+		if !ok {
+			return
+		}
+		if user.ID == authentication.GetClaims(c).ID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You cannot deactivate your own account"})
+			return
+		}
+		if user.Status == amazon.UserDeleted {
+			c.JSON(http.StatusConflict, gin.H{"error": "User is scheduled for deletion, restore it first"})
+			return
+		}
+
+		if !setAccountStatus(c, stores, client, user, amazon.UserDeactivated, 0, authentication.AuditUserDeactivate) {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "User Deactivated!"})
+	}
+}
+
+func ReactivateUserReq(stores store.Stores, client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := findAccount(c, stores.Users, c.Param("id"))
+		if !ok {
+			return
+		}
+		if user.Status != amazon.UserDeactivated {
+			c.JSON(http.StatusConflict, gin.H{"error": "User is not deactivated"})
+			return
+		}
+
+		if !setAccountStatus(c, stores, client, user, amazon.UserActive, 0, authentication.AuditUserReactivate) {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "User Reactivated!"})
+	}
+}
+
+// RestoreUserReq cancels a pending deletion. Sessions revoked by the deletion
+// stay revoked, the user logs in again.
+func RestoreUserReq(stores store.Stores, client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := findAccount(c, stores.Users, c.Param("id"))
+		if !ok {
+			return
+		}
+		if user.Status != amazon.UserDeleted {
+			c.JSON(http.StatusConflict, gin.H{"error": "User is not scheduled for deletion"})
+			return
+		}
+
+		if !setAccountStatus(c, stores, client, user, amazon.UserActive, 0, authentication.AuditUserRestore) {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "User Restored!"})
+	}
+}
+
+func findAccount(c *gin.Context, users store.UserStore, id string) (*amazon.User, bool) {
+	user, err := users.GetUser(id)
+	if errors.Is(err, amazon.ErrUserNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		return nil, false
+	}
+	return user, true
+}
+
+// setAccountStatus stores the new status, logs everyone out of an account
+// that stops being active and records who did it.
+func setAccountStatus(c *gin.Context, stores store.Stores, client *dynamodb.Client, user *amazon.User, status string, purgeAt int64, action string) bool {
+	if err := stores.Users.SetStatus(user.ID, status, purgeAt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update account status"})
+		return false
+	}
+
+	if status != amazon.UserActive {
+		if err := stores.Sessions.RevokeUserSessions(user.ID); err != nil {
+			log.Printf("Failed to revoke sessions of %s: %v", user.ID, err)
+		}
+	}
+
+	event := amazon.AuditEvent{
+		Action:   action,
+		ActorID:  authentication.GetClaims(c).ID,
+		TargetID: user.ID,
+		IP:       c.ClientIP(),
+		Details:  map[string]string{"previousStatus": user.Status},
+	}
+	if err := authentication.RecordAudit(client, event); err != nil {
+		log.Printf("Failed to audit %s of %s: %v", action, user.ID, err)
+	}
+	return true
+}
+
+// rejectInactiveLogin answers 403 for deactivated and deleted accounts. It
+// runs after the credentials were checked, so it reveals nothing to someone
+// who does not know them.
+func rejectInactiveLogin(c *gin.Context, user amazon.User) bool {
+	switch {
+	case user.IsActive():
+		return false
+	case user.Status == amazon.UserDeleted:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is scheduled for deletion"})
+	default:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is deactivated"})
+	}
+	return true
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"xstudious-guide/amazon"
+	"xstudious-guide/authentication"
+	"xstudious-guide/store"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/gin-gonic/gin"
+)
+
+// ImpersonateUserReq mints a short-lived access token for another user. The
+// token belongs to its own session, which ends it early when revoked, and is
+// only handed out once the audit event is written, so there is no
+// impersonation without a trail.
+func ImpersonateUserReq(stores store.Stores, client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Reason string `json:"reason"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || req.Reason == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required"})
+			return
+		}
+
+		admin := authentication.GetClaims(c)
+
+		target, err := stores.Users.GetUser(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if !target.IsActive() {
+			c.JSON(http.StatusConflict, gin.H{"error": "User is " + target.Status})
+			return
+		}
+
+		claims, err := authentication.NewImpersonationClaims(admin, *target)
+		if err != nil {
+			if errors.Is(err, authentication.ErrCannotImpersonate) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "This user cannot be impersonated"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+			return
+		}
+
+		if err := authentication.NewImpersonationSession(stores.Sessions, &claims, c.ClientIP()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+			return
+		}
+
+		accessToken, err := authentication.NewAccessToken(claims)
+		if err != nil {
+			endImpersonationSession(stores.Sessions, claims.SessionID)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+			return
+		}
+
+		event := amazon.AuditEvent{
+			Action:   authentication.AuditImpersonationStart,
+			ActorID:  admin.ID,
+			TargetID: target.ID,
+			IP:       c.ClientIP(),
+			Details: map[string]string{
+				"reason":    req.Reason,
+				"tokenId":   claims.Id,
+				"sessionId": claims.SessionID,
+				"expiresAt": fmt.Sprintf("%d", claims.ExpiresAt),
+			},
+		}
+		if err := authentication.RecordAudit(client, event); err != nil {
+			log.Printf("Failed to audit impersonation of %s by %s: %v", target.ID, admin.ID, err)
+			endImpersonationSession(stores.Sessions, claims.SessionID)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record impersonation"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":     "Impersonating " + target.Email,
+			"accessToken": accessToken,
+			"sessionId":   claims.SessionID,
+			"expiresIn":   int(authentication.ImpersonationTTL.Seconds()),
+		})
+	}
+}
+
+// EndImpersonationReq revokes an impersonation session before its token
+// expires.
+func EndImpersonationReq(sessions store.SessionStore, client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		session, err := sessions.GetSession(c.Param("id"))
+		if err != nil || session.ActorID == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Impersonation session not found"})
+			return
+		}
+
+		if err := sessions.RevokeSession(session.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to end impersonation"})
+			return
+		}
+
+		event := amazon.AuditEvent{
+			Action:   authentication.AuditImpersonationEnd,
+			ActorID:  authentication.GetClaims(c).ID,
+			TargetID: session.UserID,
+			IP:       c.ClientIP(),
+			Details:  map[string]string{"sessionId": session.ID},
+		}
+		if err := authentication.RecordAudit(client, event); err != nil {
+			log.Printf("Failed to audit end of impersonation session %s: %v", session.ID, err)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Impersonation ended"})
+	}
+}
+
+// endImpersonationSession revokes a session whose token was never handed out.
+func endImpersonationSession(sessions store.SessionStore, id string) {
+	if err := sessions.RevokeSession(id); err != nil {
+		log.Printf("Failed to revoke impersonation session %s: %v", id, err)
+	}
+}
+
+func GetUserAuditLogReq(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		events, err := amazon.GetAuditEventsForTarget(client, "audit_log", c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get audit log"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"events": events})
+	}
+}
+
+// CreateServiceClientReq registers a service client. An admin can only
+// delegate scopes they hold themselves.
+func CreateServiceClientReq(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Name   string   `json:"name"`
+			Scopes []string `json:"scopes"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || req.Name == "" || len(req.Scopes) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A name and at least one scope are required"})
+			return
+		}
+
+		admin := authentication.GetClaims(c)
+		for _, scope := range req.Scopes {
+			if !authentication.HasPermission(admin, scope) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Cannot grant scope " + scope})
+				return
+			}
+		}
+
+		secret, sc, err := authentication.NewServiceClient(client, req.Name, req.Scopes, admin.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create service client"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message":      "Service client created. Copy the secret now, it will not be shown again.",
+			"clientId":     sc.ID,
+			"clientSecret": secret,
+			"client":       sc,
+		})
+	}
+}
+
+func GetServiceClientsReq(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clients, err := amazon.GetAllServiceClients(client, "service_clients")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get service clients"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"clients": clients})
+	}
+}
+
+func RevokeServiceClientReq(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := amazon.RevokeServiceClient(client, "service_clients", c.Param("id")); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Service client not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Service client revoked"})
+	}
+}
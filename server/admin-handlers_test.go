@@ -0,0 +1,38 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"xstudious-guide/authentication"
+)
+
+func TestRevokingImpersonationSessionEndsImpersonation(t *testing.T) {
+	s := newTestServer(t)
+	admin := authentication.NewUserClaims(s.addUser("u_admin", "admin@example.com", "user", "admin"))
+	alice := s.addUser("u_alice", "alice@example.com")
+
+	claims, err := authentication.NewImpersonationClaims(&admin, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := authentication.NewImpersonationSession(s.stores.Sessions, &claims, "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	token, err := authentication.NewAccessToken(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectStatus(t, s.do(http.MethodGet, "/users/u_alice", nil, bearer(token)...), http.StatusOK)
+
+	// alice sees the session among her own and can end it
+	sessions, err := s.stores.Sessions.GetUserSessions("u_alice")
+	if err != nil || len(sessions) != 1 || sessions[0].ActorID != "u_admin" {
+		t.Fatalf("sessions = %+v, %v, want the impersonation session", sessions, err)
+	}
+	if err := s.stores.Sessions.RevokeSession(sessions[0].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	expectStatus(t, s.do(http.MethodGet, "/users/u_alice", nil, bearer(token)...), http.StatusUnauthorized)
+}
@@ -3,8 +3,6 @@ package server
 import (
 	"context"
 	"net/http"
-	"strings"
-	"xstudious-guide/authentication"
 
 	"github.com/gin-gonic/gin"
 	openai "github.com/sashabaranov/go-openai"
@@ -34,21 +32,6 @@ func SendBasicPrompt(client *openai.Client) gin.HandlerFunc {
 			c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "Method not allowed"})
 			return
 		}
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header missing"})
-			return
-		}
-		token := strings.TrimPrefix(authHeader, "Bearer ")
-		if token == authHeader {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token format"})
-			return
-		}
-		claims := authentication.ParseAccessToken(token)
-		if claims == nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to verify token"})
-			return
-		}
 
 		resp, err := client.CreateChatCompletion(
 			context.Background(),
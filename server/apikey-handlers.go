@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net/http"
+	"xstudious-guide/authentication"
+	"xstudious-guide/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+func CreateAPIKeyReq(keys store.APIKeyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Name   string   `json:"name"`
+			Scopes []string `json:"scopes"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || req.Name == "" || len(req.Scopes) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name and at least one scope are required"})
+			return
+		}
+
+		claims := authentication.GetClaims(c)
+
+		// a key can never do more than its owner
+		for _, scope := range req.Scopes {
+			if !authentication.HasPermission(claims, scope) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "You do not have the scope " + scope})
+				return
+			}
+		}
+
+		raw, key, err := authentication.NewAPIKey(keys, claims.ID, req.Name, req.Scopes)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "API key created. Copy it now, it will not be shown again.",
+			"apiKey":  raw,
+			"key":     key,
+		})
+	}
+}
+
+func GetAPIKeysReq(keyStore store.APIKeyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := authentication.GetClaims(c)
+
+		keys, err := keyStore.GetUserAPIKeys(claims.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch API keys"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"keys": keys})
+	}
+}
+
+func RevokeAPIKeyReq(keys store.APIKeyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := authentication.GetClaims(c)
+
+		if err := keys.RevokeAPIKey(c.Param("id"), claims.ID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+	"xstudious-guide/authentication"
+
+	"github.com/gin-gonic/gin"
+)
+
+// An API key must not reach a DenyAPIKeys route, neither directly nor through
+// a token derived from it.
+func TestAPIKeysCannotReachInteractiveRoutes(t *testing.T) {
+	s := newTestServer(t)
+	s.addUser("u_admin", "admin@example.com", "user", "admin")
+
+	scopes := []string{
+		authentication.PermUsersRead,
+		authentication.PermUsersWrite,
+		authentication.PermUsersAdmin,
+	}
+	raw, key, err := authentication.NewAPIKey(s.stores.APIKeys, "u_admin", "ci", scopes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiKey := []string{"Authorization", "ApiKey " + raw}
+
+	// the key itself works where keys are allowed
+	expectStatus(t, s.do(http.MethodGet, "/users/u_admin", nil, apiKey...), http.StatusOK)
+
+	denied := []struct{ method, path string }{
+		{http.MethodGet, "/me/sessions"},
+		{http.MethodDelete, "/me/sessions/s_1"},
+		{http.MethodPost, "/api-keys"},
+		{http.MethodGet, "/api-keys"},
+		{http.MethodDelete, "/api-keys/" + key.ID},
+		{http.MethodPost, "/mfa/totp/enroll"},
+		{http.MethodPut, "/users/password"},
+		{http.MethodPost, "/orgs/o_1/switch"},
+		{http.MethodPost, "/admin/impersonate/u_admin"},
+		{http.MethodPost, "/tokens/scoped"},
+	}
+	for _, d := range denied {
+		rec := s.do(d.method, d.path, gin.H{"scopes": []string{authentication.PermUsersRead}}, apiKey...)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s %s with an API key: status = %d, want 403", d.method, d.path, rec.Code)
+		}
+	}
+
+	// a token derived from the key, had one been minted, is refused too
+	user, err := s.stores.Users.GetUser("u_admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := authentication.NewUserClaims(*user)
+	claims.Scopes = scopes
+	claims.APIKeyID = key.ID
+	derived, err := authentication.NewScopedToken(&claims, scopes, time.Minute, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range denied {
+		rec := s.do(d.method, d.path, nil, bearer(derived)...)
+		if rec.Code != http.StatusUnauthorized && rec.Code != http.StatusForbidden {
+			t.Errorf("%s %s with a key-derived token: status = %d, want 401 or 403", d.method, d.path, rec.Code)
+		}
+	}
+}
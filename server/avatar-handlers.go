@@ -0,0 +1,198 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"xstudious-guide/amazon"
+	"xstudious-guide/authentication"
+	"xstudious-guide/store"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxAvatarUpload    = 5 << 20 // bytes
+	maxAvatarDimension = 4096    // pixels, checked before decoding
+	avatarSize         = 256
+)
+
+func UploadAvatarReq(client *s3.Client, users store.UserStore) gin.HandlerFunc {
+	presigner := s3.NewPresignClient(client)
+
+	return func(c *gin.Context) {
+		claims := authentication.GetClaims(c)
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxAvatarUpload+(64<<10))
+
+		file, _, err := c.Request.FormFile("avatar")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retrieve avatar, send an image of at most 5 MB"})
+			return
+		}
+		defer file.Close()
+
+		data, err := io.ReadAll(io.LimitReader(file, maxAvatarUpload+1))
+		if err != nil || len(data) > maxAvatarUpload {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Avatar must be at most 5 MB"})
+			return
+		}
+
+		avatar, err := processAvatar(data)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid avatar: " + err.Error()})
+			return
+		}
+
+		user, err := users.GetUser(claims.ID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		// a fresh key per upload so cached URLs of the old avatar go stale
+		filename := fmt.Sprintf("%s/avatar-%s.png", claims.ID, ShortUUID())
+		fileKey, _, err := amazon.UploadFile(client, presigner, filename, bytes.NewReader(avatar))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload avatar"})
+			return
+		}
+
+		if err := users.SetAvatar(claims.ID, fileKey, user.Version); err != nil {
+			// the upload is not referenced by anyone, don't leave it behind
+			removeAvatarObject(client, fileKey)
+			if errors.Is(err, amazon.ErrVersionConflict) {
+				c.JSON(http.StatusPreconditionFailed, gin.H{"error": "User has changed, fetch it again"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save avatar"})
+			return
+		}
+		removeAvatarObject(client, user.AvatarKey)
+
+		user.AvatarKey = fileKey
+		user.Version++
+		c.Header("ETag", userETag(user.Version))
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Avatar Updated!",
+			"profile": profileResponse(presigner, user),
+		})
+	}
+}
+
+func DeleteAvatarReq(client *s3.Client, users store.UserStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := authentication.GetClaims(c)
+
+		user, err := users.GetUser(claims.ID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if user.AvatarKey == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No avatar set"})
+			return
+		}
+
+		if err := users.SetAvatar(claims.ID, "", user.Version); err != nil {
+			if errors.Is(err, amazon.ErrVersionConflict) {
+				c.JSON(http.StatusPreconditionFailed, gin.H{"error": "User has changed, fetch it again"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove avatar"})
+			return
+		}
+		removeAvatarObject(client, user.AvatarKey)
+
+		c.JSON(http.StatusOK, gin.H{"message": "Avatar Removed!"})
+	}
+}
+
+// removeAvatarObject deletes a replaced avatar. The user record no longer
+// points at it, so a failure only leaves an orphaned object behind.
+func removeAvatarObject(client *s3.Client, key string) {
+	if key == "" {
+		return
+	}
+	if err := amazon.DeleteFile(client, key); err != nil {
+		log.Printf("Failed to delete old avatar %s: %v", key, err)
+	}
+}
+
+// processAvatar checks that data is a JPEG, PNG or GIF image of sane
+// dimensions and turns it into a square PNG of avatarSize pixels. Re-encoding
+// also strips metadata such as EXIF location.
+func processAvatar(data []byte) ([]byte, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("must be a JPEG, PNG or GIF image")
+	}
+	if cfg.Width > maxAvatarDimension || cfg.Height > maxAvatarDimension {
+		return nil, fmt.Errorf("must be at most %dx%d pixels", maxAvatarDimension, maxAvatarDimension)
+	}
+	if cfg.Width == 0 || cfg.Height == 0 {
+		return nil, fmt.Errorf("image is empty")
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s image", format)
+	}
+
+	var out bytes.Buffer
+	if err := png.Encode(&out, resizeSquare(img, avatarSize)); err != nil {
+		return nil, fmt.Errorf("failed to encode avatar: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// resizeSquare center-crops img to a square and scales it to size x size,
+// averaging the source pixels that fall into each target pixel.
+func resizeSquare(img image.Image, size int) *image.RGBA {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	crop := image.Rect(0, 0, side, side)
+
+	src := image.NewRGBA(crop)
+	offset := image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2)
+	draw.Draw(src, crop, img, offset, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		y0 := y * side / size
+		y1 := max((y+1)*side/size, y0+1)
+		for x := 0; x < size; x++ {
+			x0 := x * side / size
+			x1 := max((x+1)*side/size, x0+1)
+
+			var r, g, bl, a, n int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += int(p[0])
+					g += int(p[1])
+					bl += int(p[2])
+					a += int(p[3])
+					n++
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(bl / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
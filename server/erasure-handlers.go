@@ -0,0 +1,129 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"xstudious-guide/amazon"
+	"xstudious-guide/authentication"
+	"xstudious-guide/email"
+	"xstudious-guide/store"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+	"github.com/resend/resend-go/v2"
+)
+
+// EraseMeReq erases the caller's account right away, without the grace
+// period of DELETE /users/:id. Accounts with a password must confirm it.
+func EraseMeReq(stores store.Stores, dynamo *dynamodb.Client, s3client *s3.Client, emailClient *resend.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Password string `json:"password"`
+			Confirm  bool   `json:"confirm"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if !req.Confirm {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Set confirm to true to erase your account, this cannot be undone"})
+			return
+		}
+
+		user, ok := findAccount(c, stores.Users, authentication.GetClaims(c).ID)
+		if !ok {
+			return
+		}
+		if user.Password != "" && !authentication.CheckPasswordHash(req.Password, user.Password) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Password is incorrect"})
+			return
+		}
+
+		eraseAccount(c, stores, dynamo, s3client, emailClient, *user)
+	}
+}
+
+// EraseUserReq lets an admin erase an account, for erasure requests that
+// reach support instead of the user's own settings.
+func EraseUserReq(stores store.Stores, dynamo *dynamodb.Client, s3client *s3.Client, emailClient *resend.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := findAccount(c, stores.Users, c.Param("id"))
+		if !ok {
+			return
+		}
+		if user.ID == authentication.GetClaims(c).ID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Use POST /me/erasure to erase your own account"})
+			return
+		}
+
+		eraseAccount(c, stores, dynamo, s3client, emailClient, *user)
+	}
+}
+
+// VerifyErasureReceiptReq checks the signature of a receipt and returns its
+// claims, so a user can prove an erasure to a third party.
+func VerifyErasureReceiptReq() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Receipt string `json:"receipt"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || req.Receipt == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing receipt"})
+			return
+		}
+
+		receipt := authentication.ParseErasureReceipt(req.Receipt)
+		if receipt == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid erasure receipt"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"valid": true, "receipt": receipt})
+	}
+}
+
+// eraseAccount purges the user, signs a receipt of what was erased and what
+// was kept, and emails it to the address that is about to be forgotten.
+// A failed purge can be retried, every step of it is idempotent.
+func eraseAccount(c *gin.Context, stores store.Stores, dynamo *dynamodb.Client, s3client *s3.Client, emailClient *resend.Client, user amazon.User) {
+	summary, err := purgeUser(stores, dynamo, s3client, user)
+	if err != nil {
+		log.Printf("Failed to erase user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to erase account, please try again"})
+		return
+	}
+
+	signed, receipt, err := authentication.NewErasureReceipt(user.ID, user.Email, summary.erased(), summary.retained())
+	if err != nil {
+		log.Printf("Failed to sign erasure receipt for %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Account erased, but the receipt could not be signed"})
+		return
+	}
+
+	// a self-erasure must not leave the user's ID or IP behind as the actor
+	ref := erasedUserRef(user.ID)
+	event := amazon.AuditEvent{
+		Action:   authentication.AuditUserErase,
+		ActorID:  ref,
+		TargetID: ref,
+		Details:  map[string]string{"receiptId": receipt.Id},
+	}
+	if actor := authentication.GetClaims(c).ID; actor != user.ID {
+		event.ActorID = actor
+		event.IP = c.ClientIP()
+	}
+	if err := authentication.RecordAudit(dynamo, event); err != nil {
+		log.Printf("Failed to audit erasure %s: %v", receipt.Id, err)
+	}
+
+	if err := email.SendEmail(emailClient, email.ErasureReceiptEmail(user.Email, user.Name, receipt.Id, signed)); err != nil {
+		log.Printf("Failed to send erasure receipt %s: %v", receipt.Id, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Account erased!",
+		"receiptId": receipt.Id,
+		"receipt":   signed,
+	})
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// userETag is the strong validator of a user, its version.
+func userETag(version int64) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// ifMatch reports whether the request's If-Match header allows a write to a
+// user at version. A missing header allows it, weak tags never match.
+func ifMatch(c *gin.Context, version int64) bool {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return true
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == userETag(version) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+	"xstudious-guide/amazon"
+	"xstudious-guide/authentication"
+	"xstudious-guide/email"
+	"xstudious-guide/store"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+	"github.com/resend/resend-go/v2"
+)
+
+const (
+	exportCooldown = time.Hour
+	exportLinkTTL  = 24 * time.Hour
+)
+
+// ExportMyDataReq starts a data export. The archive is built in the
+// background and the user gets a download link by email, so the request
+// only answers 202.
+func ExportMyDataReq(stores store.Stores, dynamo *dynamodb.Client, s3client *s3.Client, emailClient *resend.Client) gin.HandlerFunc {
+	presigner := s3.NewPresignClient(s3client)
+
+	return func(c *gin.Context) {
+		user, ok := findAccount(c, stores.Users, authentication.GetClaims(c).ID)
+		if !ok {
+			return
+		}
+		if !user.IsEmailVerified() {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Verify your email address first, the export is sent by email"})
+			return
+		}
+
+		// claimed before the export starts, so parallel requests get one export
+		now := time.Now()
+		if err := stores.Users.ClaimExportRequest(user.ID, now.Unix(), now.Add(-exportCooldown).Unix()); err != nil {
+			if errors.Is(err, amazon.ErrThrottled) {
+				c.JSON(http.StatusTooManyRequests, gin.H{"error": "An export was requested less than an hour ago"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start export"})
+			return
+		}
+
+		ip := c.ClientIP()
+		go func() {
+			if err := exportUserData(stores, dynamo, s3client, presigner, emailClient, *user, ip); err != nil {
+				log.Printf("Data export for %s failed: %v", user.ID, err)
+			}
+		}()
+
+		c.JSON(http.StatusAccepted, gin.H{"message": "Export started, you will receive an email with a download link"})
+	}
+}
+
+// exportUserData builds the archive, stores it next to the user's uploads
+// and emails a link. The audit event keeps the key, so erasing the account
+// also removes its exports.
+func exportUserData(stores store.Stores, dynamo *dynamodb.Client, s3client *s3.Client, presigner *s3.PresignClient, emailClient *resend.Client, user amazon.User, ip string) error {
+	archive, err := buildExportArchive(stores, dynamo, s3client, user)
+	if err != nil {
+		return err
+	}
+
+	filename := fmt.Sprintf("exports/%s/export-%d.zip", user.ID, time.Now().Unix())
+	fileKey, _, err := amazon.UploadFile(s3client, presigner, filename, bytes.NewReader(archive))
+	if err != nil {
+		return err
+	}
+
+	event := amazon.AuditEvent{
+		Action:   authentication.AuditUserExport,
+		ActorID:  user.ID,
+		TargetID: user.ID,
+		IP:       ip,
+		Details:  map[string]string{"fileKey": fileKey},
+	}
+	if err := authentication.RecordAudit(dynamo, event); err != nil {
+		log.Printf("Failed to audit export of %s: %v", user.ID, err)
+	}
+
+	link, err := amazon.PresignFile(presigner, fileKey, exportLinkTTL)
+	if err != nil {
+		return err
+	}
+	return email.SendEmail(emailClient, email.DataExportEmail(user.Email, user.Name, link, time.Now().Add(exportLinkTTL)))
+}
+
+// exportManifest describes the archive. AI prompts and sent emails are
+// listed as not stored rather than left out, so the export answers for them.
+type exportManifest struct {
+	UserID      string            `json:"userId"`
+	GeneratedAt int64             `json:"generatedAt"`
+	Contents    map[string]string `json:"contents"`
+	NotStored   map[string]string `json:"notStored"`
+}
+
+func buildExportArchive(stores store.Stores, dynamo *dynamodb.Client, s3client *s3.Client, user amazon.User) ([]byte, error) {
+	files, err := stores.Files.GetUserFiles(user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("listing files: %w", err)
+	}
+	sessions, err := stores.Sessions.GetUserSessions(user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("listing sessions: %w", err)
+	}
+	keys, err := stores.APIKeys.GetUserAPIKeys(user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("listing api keys: %w", err)
+	}
+	passkeys, err := amazon.GetUserPasskeys(dynamo, "passkeys", user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("listing passkeys: %w", err)
+	}
+	identities, err := amazon.GetUserIdentities(dynamo, "identities", user.ID)
+	if err != nil {
+		return nil, err
+	}
+	memberships, err := amazon.GetUserMemberships(dynamo, "memberships", user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("listing memberships: %w", err)
+	}
+	events, err := amazon.GetAuditEventsForTarget(dynamo, "audit_log", user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("listing audit events: %w", err)
+	}
+
+	type exportFile struct {
+		FileID   string `json:"fileId"`
+		FileKey  string `json:"fileKey"`
+		OrgID    string `json:"orgId,omitempty"`
+		Uploaded int64  `json:"uploaded"`
+	}
+	fileList := make([]exportFile, 0, len(files))
+	for _, f := range files {
+		fileList = append(fileList, exportFile{FileID: f.FileID, FileKey: f.FileKey, OrgID: f.OrgID, Uploaded: f.Uploaded})
+	}
+
+	manifest := exportManifest{
+		UserID:      user.ID,
+		GeneratedAt: time.Now().Unix(),
+		Contents: map[string]string{
+			"profile.json":      "account, profile and preferences",
+			"files.json":        "metadata of uploaded files, download them from /files",
+			"sessions.json":     "signed in devices",
+			"api-keys.json":     "API keys, without the secrets",
+			"passkeys.json":     "registered passkeys, without the credentials",
+			"identities.json":   "linked login providers",
+			"memberships.json":  "organization memberships",
+			"audit-events.json": "security and account events about you",
+		},
+		NotStored: map[string]string{
+			"aiConversations": "prompts sent to /ai/basic are forwarded to OpenAI and not kept by this service",
+			"emailLog":        "emails are sent through Resend and not logged by this service",
+		},
+	}
+
+	entries := []struct {
+		name string
+		data interface{}
+	}{
+		{"manifest.json", manifest},
+		{"profile.json", newUserResponse(user, visibilitySelf)},
+		{"files.json", fileList},
+		{"sessions.json", sessions},
+		{"api-keys.json", keys},
+		{"passkeys.json", passkeys},
+		{"identities.json", identities},
+		{"memberships.json", memberships},
+		{"audit-events.json", events},
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		w, err := zw.Create(entry.name)
+		if err != nil {
+			return nil, err
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(entry.data); err != nil {
+			return nil, fmt.Errorf("writing %s: %w", entry.name, err)
+		}
+	}
+
+	if user.AvatarKey != "" {
+		avatar, err := amazon.ReadFile(s3client, user.AvatarKey)
+		if err != nil {
+			return nil, err
+		}
+		w, err := zw.Create("avatar.png")
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(avatar); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
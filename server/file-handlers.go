@@ -5,18 +5,18 @@ import (
 	"fmt"
 	"net/http"
 	"os"
-	"strings"
+	"path"
 	"time"
 	"xstudious-guide/amazon"
 	"xstudious-guide/authentication"
+	"xstudious-guide/store"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/gin-gonic/gin"
 )
 
-func Upload(client *s3.Client, dynamo *dynamodb.Client) gin.HandlerFunc {
+func Upload(client *s3.Client, files store.FileStore) gin.HandlerFunc {
 	presigner := s3.NewPresignClient(client)
 
 	return func(c *gin.Context) {
@@ -25,21 +25,7 @@ func Upload(client *s3.Client, dynamo *dynamodb.Client) gin.HandlerFunc {
 			return
 		}
 
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header missing"})
-			return
-		}
-		token := strings.TrimPrefix(authHeader, "Bearer ")
-		if token == authHeader {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token format"})
-			return
-		}
-		claims := authentication.ParseAccessToken(token)
-		if claims == nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to verify token"})
-			return
-		}
+		claims := authentication.GetClaims(c)
 
 		id := ShortUUID()
 		fileID := fmt.Sprintf("f_%s", id)
@@ -52,7 +38,13 @@ func Upload(client *s3.Client, dynamo *dynamodb.Client) gin.HandlerFunc {
 		}
 		defer file.Close()
 
-		fileKey, presignedURL, err := amazon.UploadFile(client, presigner, header.Filename, file)
+		// keys are namespaced per user, and per org inside an org, so uploads
+		// cannot overwrite each other
+		filename := fmt.Sprintf("%s/%s", userID, path.Base(header.Filename))
+		if claims.OrgID != "" {
+			filename = fmt.Sprintf("orgs/%s/%s", claims.OrgID, filename)
+		}
+		fileKey, presignedURL, err := amazon.UploadFile(client, presigner, filename, file)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -61,11 +53,12 @@ func Upload(client *s3.Client, dynamo *dynamodb.Client) gin.HandlerFunc {
 		userFile := amazon.UserFile{
 			UserID:   userID,
 			FileID:   fileID,
+			OrgID:    claims.OrgID,
 			FileKey:  fileKey,
 			Uploaded: time.Now().Unix(),
 		}
 
-		if err := amazon.SaveUserFile(dynamo, "files", userFile); err != nil {
+		if err := files.SaveFile(userFile); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save user file"})
 			return
 		}
@@ -79,33 +72,31 @@ func Upload(client *s3.Client, dynamo *dynamodb.Client) gin.HandlerFunc {
 	}
 }
 
-func GetUserFilesHandler(dynamo *dynamodb.Client, presigner *s3.PresignClient) gin.HandlerFunc {
+func GetUserFilesHandler(files store.FileStore, presigner *s3.PresignClient) gin.HandlerFunc {
 	return func(c *gin.Context) {
 
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header missing"})
-			return
-		}
-		token := strings.TrimPrefix(authHeader, "Bearer ")
-		if token == authHeader {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token format"})
-			return
-		}
-		claims := authentication.ParseAccessToken(token)
-		if claims == nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to verify token"})
+		claims := authentication.GetClaims(c)
+
+		limit, cursor, ok := pageParams(c)
+		if !ok {
 			return
 		}
 
-		userID := claims.ID
-
-		files, err := amazon.GetUserFiles(dynamo, userID)
+		visible, err := visibleFiles(files, claims)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user files"})
 			return
 		}
 
+		// oldest first, the file ID breaks ties between uploads in the same second
+		page, ok := pageSlice(visible, func(f amazon.UserFile) string {
+			return fmt.Sprintf("%020d/%s", f.Uploaded, f.FileID)
+		}, limit, cursor)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+
 		type FileResponse struct {
 			FileID       string `json:"fileId"`
 			FileKey      string `json:"fileKey"`
@@ -116,7 +107,7 @@ func GetUserFilesHandler(dynamo *dynamodb.Client, presigner *s3.PresignClient) g
 		var response []FileResponse
 		bucketName := os.Getenv("AWS_BUCKET")
 
-		for _, f := range files {
+		for _, f := range page.Items {
 			presignedReq, err := presigner.PresignGetObject(context.TODO(), &s3.GetObjectInput{
 				Bucket: aws.String(bucketName),
 				Key:    aws.String(f.FileKey),
@@ -133,14 +124,11 @@ func GetUserFilesHandler(dynamo *dynamodb.Client, presigner *s3.PresignClient) g
 			})
 		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"userId": userID,
-			"files":  response,
-		})
+		c.JSON(http.StatusOK, newPage(response, page.NextCursor))
 	}
 }
 
-func Download(client *s3.Client) gin.HandlerFunc {
+func Download(client *s3.Client, files store.FileStore) gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		if c.Request.Method != http.MethodGet {
@@ -148,21 +136,7 @@ func Download(client *s3.Client) gin.HandlerFunc {
 			return
 		}
 
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header missing"})
-			return
-		}
-		token := strings.TrimPrefix(authHeader, "Bearer ")
-		if token == authHeader {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token format"})
-			return
-		}
-		claims := authentication.ParseAccessToken(token)
-		if claims == nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to verify token"})
-			return
-		}
+		claims := authentication.GetClaims(c)
 
 		filename := c.Query("filename")
 		if filename == "" {
@@ -170,6 +144,18 @@ func Download(client *s3.Client) gin.HandlerFunc {
 			return
 		}
 
+		if !authentication.HasPermission(claims, authentication.PermFilesAdmin) {
+			owned, err := ownsFile(files, claims, filename)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user files"})
+				return
+			}
+			if !owned {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed to download this file"})
+				return
+			}
+		}
+
 		url, err := amazon.DownloadFile(client, filename)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate download URL"})
@@ -181,3 +167,38 @@ func Download(client *s3.Client) gin.HandlerFunc {
 		})
 	}
 }
+
+// visibleFiles returns the files of the active org, or the caller's personal
+// files outside of an org. Org files never show up in a personal listing, so
+// they stay with the org when a member leaves.
+func visibleFiles(files store.FileStore, claims *authentication.UserClaims) ([]amazon.UserFile, error) {
+	if claims.OrgID != "" {
+		return files.GetOrgFiles(claims.OrgID)
+	}
+
+	mine, err := files.GetUserFiles(claims.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var personal []amazon.UserFile
+	for _, f := range mine {
+		if f.OrgID == "" {
+			personal = append(personal, f)
+		}
+	}
+	return personal, nil
+}
+
+func ownsFile(files store.FileStore, claims *authentication.UserClaims, fileKey string) (bool, error) {
+	visible, err := visibleFiles(files, claims)
+	if err != nil {
+		return false, err
+	}
+	for _, f := range visible {
+		if f.FileKey == fileKey {
+			return true, nil
+		}
+	}
+	return false, nil
+}
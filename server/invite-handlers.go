@@ -0,0 +1,86 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"xstudious-guide/authentication"
+	"xstudious-guide/email"
+	"xstudious-guide/store"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/gin-gonic/gin"
+	"github.com/resend/resend-go/v2"
+)
+
+const (
+	defaultInviteTTL = 7 * 24 * time.Hour
+	maxInviteTTL     = 30 * 24 * time.Hour
+)
+
+// registrationInviteOnly is switched on with REGISTRATION_MODE=invite_only.
+// New accounts then need an invite, through /register as well as OIDC.
+func registrationInviteOnly() bool {
+	return os.Getenv("REGISTRATION_MODE") == "invite_only"
+}
+
+func CreateInviteReq(users store.UserStore, client *dynamodb.Client, emailClient *resend.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Email          string `json:"email"`
+			Role           string `json:"role"`
+			ExpiresInHours int    `json:"expiresInHours"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || req.Email == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "An email is required"})
+			return
+		}
+		if req.Role == "" {
+			req.Role = authentication.RoleUser
+		}
+		if !authentication.IsKnownRole(req.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role " + req.Role})
+			return
+		}
+
+		ttl := defaultInviteTTL
+		if req.ExpiresInHours > 0 {
+			ttl = time.Duration(req.ExpiresInHours) * time.Hour
+		}
+		if ttl > maxInviteTTL {
+			ttl = maxInviteTTL
+		}
+
+		address := strings.ToLower(req.Email)
+		if _, err := users.GetUserByEmail(address); err == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "A user with this email already exists"})
+			return
+		}
+
+		admin := authentication.GetClaims(c)
+
+		token, err := authentication.NewInviteToken(client, address, req.Role, admin.ID, ttl)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invite"})
+			return
+		}
+
+		expires := time.Now().Add(ttl)
+		link := email.AppURL("/register?invite=" + url.QueryEscape(token))
+		if err := email.SendEmail(emailClient, email.InviteEmail(address, admin.Name, link, expires)); err != nil {
+			log.Printf("Failed to send invite to %s: %v", address, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Invite created but the email could not be sent"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message":   "Invite sent!",
+			"email":     address,
+			"role":      req.Role,
+			"expiresAt": expires.Unix(),
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Emailed links open a page first and only use up their token when the page
+// is submitted. Mail scanners and link previews fetch links with GET, so they
+// can no longer spend a token before the user gets to it.
+
+// linkField is an input the user fills in on a link page.
+type linkField struct {
+	Name  string
+	Label string
+	Type  string
+	Value string
+}
+
+// linkConfirmPage answers the GET of an emailed link with a form that POSTs
+// the token, and any fields the user fills in, back to the same path.
+func linkConfirmPage(c *gin.Context, title, text, button string, fields ...linkField) {
+	linkFormPage(c, title, text, button, "token", c.Query("token"), fields...)
+}
+
+// linkFormPage is linkConfirmPage for links whose token is submitted under
+// another name, such as the invite token of the registration form.
+func linkFormPage(c *gin.Context, title, text, button, tokenName, token string, fields ...linkField) {
+	var inputs strings.Builder
+	for _, f := range fields {
+		fmt.Fprintf(&inputs, `<p><label>%s <input type="%s" name="%s" value="%s" required></label></p>`,
+			html.EscapeString(f.Label), html.EscapeString(f.Type), html.EscapeString(f.Name), html.EscapeString(f.Value))
+	}
+
+	form := fmt.Sprintf(
+		`<form method="post" action="%s"><input type="hidden" name="%s" value="%s">%s<button type="submit">%s</button></form>`,
+		html.EscapeString(c.Request.URL.Path), html.EscapeString(tokenName), html.EscapeString(token), inputs.String(), html.EscapeString(button),
+	)
+	linkPage(c, http.StatusOK, title, text, form)
+}
+
+// linkErrorPage tells the user the link cannot be used.
+func linkErrorPage(c *gin.Context, title, text string) {
+	linkPage(c, http.StatusBadRequest, title, text, "")
+}
+
+func linkPage(c *gin.Context, status int, title, text, form string) {
+	// the token is in the URL, keep it out of caches and Referer headers
+	c.Header("Cache-Control", "no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+
+	body := fmt.Sprintf(
+		`<!DOCTYPE html><html><head><meta charset="utf-8"><title>%s</title></head><body><h1>%s</h1><p>%s</p>%s</body></html>`,
+		html.EscapeString(title), html.EscapeString(title), html.EscapeString(text), form,
+	)
+	c.Data(status, "text/html; charset=utf-8", []byte(body))
+}
+
+// linkToken reads the token a confirmation page submitted, as a form field
+// or as JSON for API clients.
+func linkToken(c *gin.Context) string {
+	var req struct {
+		Token string `form:"token" json:"token"`
+	}
+	if err := c.ShouldBind(&req); err != nil {
+		return ""
+	}
+	return req.Token
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestEmailedLinksNeedAPost(t *testing.T) {
+	s := newTestServer(t)
+
+	for _, path := range []string{"/verify-email", "/login/magic/callback", "/password/reset", "/register"} {
+		rec := s.do(http.MethodGet, path, nil)
+		expectStatus(t, rec, http.StatusBadRequest)
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+			t.Errorf("GET %s: Content-Type = %q, want a page", path, ct)
+		}
+		if rec.Header().Get("Cache-Control") != "no-store" {
+			t.Errorf("GET %s: page may be cached", path)
+		}
+
+		if path == "/register" {
+			continue
+		}
+		rec = s.do(http.MethodPost, path, gin.H{})
+		if rec.Code != http.StatusBadRequest && rec.Code != http.StatusUnauthorized {
+			t.Errorf("POST %s without a token: status = %d", path, rec.Code)
+		}
+	}
+}
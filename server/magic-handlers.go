@@ -0,0 +1,113 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+	"xstudious-guide/amazon"
+	"xstudious-guide/authentication"
+	"xstudious-guide/email"
+	"xstudious-guide/store"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/gin-gonic/gin"
+	"github.com/resend/resend-go/v2"
+)
+
+const (
+	magicLinkTTL      = 15 * time.Minute
+	magicLinkCooldown = time.Minute
+)
+
+func MagicLinkReq(users store.UserStore, client *dynamodb.Client, emailClient *resend.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Email string `json:"email"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || req.Email == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		// same as /password/forgot: the response never reveals whether the
+		// address has an account
+		go func(address string) {
+			user, err := users.GetUserByEmail(address)
+			if err != nil {
+				return
+			}
+
+			// at most one link a minute per account, repeats are dropped
+			// without telling the caller
+			now := time.Now()
+			if err := users.ClaimMagicLinkSend(user.ID, now.Unix(), now.Add(-magicLinkCooldown).Unix()); err != nil {
+				if !errors.Is(err, amazon.ErrThrottled) {
+					log.Printf("Failed to record magic link for %s: %v", user.ID, err)
+				}
+				return
+			}
+
+			token, err := authentication.NewOneTimeToken(client, authentication.PurposeMagicLogin, user.ID, user.Email, magicLinkTTL)
+			if err != nil {
+				log.Printf("Failed to create magic link for %s: %v", user.ID, err)
+				return
+			}
+
+			link := email.AppURL("/login/magic/callback?token=" + url.QueryEscape(token))
+			if err := email.SendEmail(emailClient, email.MagicLinkEmail(user.Email, user.Name, link)); err != nil {
+				log.Printf("Failed to send magic link to %s: %v", user.ID, err)
+			}
+		}(req.Email)
+
+		c.JSON(http.StatusOK, gin.H{"message": "If an account exists for that email, a sign-in link has been sent"})
+	}
+}
+
+// MagicLinkPageReq serves the page the emailed link opens. Signing in takes
+// a click, which POSTs the token to MagicLinkCallbackReq.
+func MagicLinkPageReq(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, err := authentication.PeekOneTimeToken(client, authentication.PurposeMagicLogin, c.Query("token")); err != nil {
+			linkErrorPage(c, "Sign in", "This sign-in link is invalid or has expired. You can ask for a new one.")
+			return
+		}
+		linkConfirmPage(c, "Sign in", "Continue to sign in to your account.", "Sign in")
+	}
+}
+
+// MagicLinkCallbackReq answers like /login. Users with MFA still have to pass
+// /login/mfa, the link only replaces the password.
+func MagicLinkCallbackReq(stores store.Stores, client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, err := authentication.ConsumeOneTimeToken(client, authentication.PurposeMagicLogin, linkToken(c))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired sign-in link"})
+			return
+		}
+
+		user, err := stores.Users.GetUser(token.UserID)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired sign-in link"})
+			return
+		}
+
+		// the link went to an address the user no longer has
+		if user.Email != token.Email {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired sign-in link"})
+			return
+		}
+
+		// opening the link proves the mailbox belongs to the user
+		if !user.IsEmailVerified() {
+			if err := stores.Users.SetEmailVerified(user.ID); err != nil {
+				log.Printf("Failed to mark %s verified after magic link: %v", user.ID, err)
+			} else {
+				user.EmailStatus = amazon.EmailVerified
+			}
+		}
+
+		completeLogin(c, stores.Sessions, *user)
+	}
+}
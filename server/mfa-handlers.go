@@ -0,0 +1,196 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"xstudious-guide/amazon"
+	"xstudious-guide/authentication"
+	"xstudious-guide/store"
+
+	"github.com/gin-gonic/gin"
+	"github.com/resend/resend-go/v2"
+)
+
+func EnrollTOTPReq(users store.UserStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := authentication.GetClaims(c)
+
+		user, err := users.GetUser(claims.ID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if user.MFAEnabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "MFA is already enabled"})
+			return
+		}
+
+		secret, err := authentication.GenerateTOTPSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+			return
+		}
+
+		if err := users.SetPendingTOTPSecret(user.ID, secret); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store secret"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":    "Scan the QR code, then confirm with a code from your app",
+			"secret":     secret,
+			"otpauthUrl": authentication.TOTPProvisioningURI(secret, user.Email),
+		})
+	}
+}
+
+func ConfirmTOTPReq(users store.UserStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Code string `json:"code"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		claims := authentication.GetClaims(c)
+
+		user, err := users.GetUser(claims.ID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if user.MFAEnabled || user.TOTPSecret == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No pending MFA enrollment"})
+			return
+		}
+
+		step, ok := authentication.ValidateTOTP(user.TOTPSecret, req.Code, user.TOTPLastStep)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+			return
+		}
+
+		codes, hashes, err := authentication.GenerateRecoveryCodes()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+			return
+		}
+
+		if err := users.EnableMFA(user.ID, hashes, step); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable MFA"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":       "MFA enabled. Store these recovery codes somewhere safe, they are shown only once.",
+			"recoveryCodes": codes,
+		})
+	}
+}
+
+func DisableTOTPReq(users store.UserStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Password string `json:"password"`
+			Code     string `json:"code"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || req.Password == "" || req.Code == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Password and code are required"})
+			return
+		}
+
+		claims := authentication.GetClaims(c)
+
+		user, err := users.GetUser(claims.ID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if !user.MFAEnabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "MFA is not enabled"})
+			return
+		}
+
+		if !authentication.CheckPasswordHash(req.Password, user.Password) || verifySecondFactor(users, user, req.Code, "") != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password or code"})
+			return
+		}
+
+		if err := users.DisableMFA(user.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable MFA"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "MFA disabled"})
+	}
+}
+
+// LoginMFAReq exchanges the challenge token from /login plus a TOTP or
+// recovery code for a normal access/refresh token pair.
+func LoginMFAReq(stores store.Stores, emailClient *resend.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			MFAToken     string `json:"mfaToken"`
+			Code         string `json:"code"`
+			RecoveryCode string `json:"recoveryCode"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || req.MFAToken == "" || (req.Code == "" && req.RecoveryCode == "") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "mfaToken and a code or recovery code are required"})
+			return
+		}
+
+		claims := authentication.ParseMFAToken(req.MFAToken)
+		if claims == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+			return
+		}
+
+		user, err := stores.Users.GetUser(claims.ID)
+		if err != nil || !user.MFAEnabled {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+			return
+		}
+
+		// codes are guessable too, so they share the login lockout
+		if wait := authentication.LoginLockedFor(stores.LoginAttempts, user.Email, c.ClientIP()); wait > 0 {
+			rejectLockedLogin(c, wait)
+			return
+		}
+
+		if err := verifySecondFactor(stores.Users, user, req.Code, req.RecoveryCode); err != nil {
+			failLogin(c, stores.LoginAttempts, emailClient, *user)
+			return
+		}
+
+		if err := authentication.ClearFailedLogins(stores.LoginAttempts, user.Email); err != nil {
+			log.Printf("Failed to clear login attempts for %s: %v", user.ID, err)
+		}
+
+		issueLoginTokens(c, stores.Sessions, *user)
+	}
+}
+
+var errInvalidSecondFactor = errors.New("invalid second factor")
+
+// verifySecondFactor accepts either a TOTP code or a recovery code and burns
+// it so it cannot be used again.
+func verifySecondFactor(users store.UserStore, user *amazon.User, code, recoveryCode string) error {
+	if code != "" {
+		step, ok := authentication.ValidateTOTP(user.TOTPSecret, code, user.TOTPLastStep)
+		if !ok {
+			return errInvalidSecondFactor
+		}
+		return users.RecordTOTPStep(user.ID, step)
+	}
+
+	hash := authentication.HashRecoveryCode(recoveryCode)
+	for i, stored := range user.RecoveryCodes {
+		if stored == hash {
+			return users.UseRecoveryCode(user.ID, i, hash)
+		}
+	}
+	return errInvalidSecondFactor
+}
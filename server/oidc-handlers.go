@@ -0,0 +1,172 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"xstudious-guide/amazon"
+	"xstudious-guide/authentication"
+	"xstudious-guide/store"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/gin-gonic/gin"
+)
+
+const oidcStateTTL = 10 * time.Minute
+
+// OIDCLoginReq starts an authorization code + PKCE flow by redirecting the
+// browser to the provider. The PKCE verifier and nonce stay server side.
+func OIDCLoginReq(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provider, ok := authentication.GetOIDCProvider(c.Param("provider"))
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown login provider"})
+			return
+		}
+
+		verifier, challenge, err := authentication.NewPKCE()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+			return
+		}
+		nonce, err := authentication.RandomToken(16)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+			return
+		}
+
+		state, err := authentication.NewStateToken(client, authentication.PurposeOIDCState, map[string]string{
+			"provider": provider.Name,
+			"verifier": verifier,
+			"nonce":    nonce,
+		}, oidcStateTTL)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+			return
+		}
+
+		authURL, err := provider.AuthCodeURL(state, nonce, challenge)
+		if err != nil {
+			log.Printf("OIDC provider %s unavailable: %v", provider.Name, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Login provider unavailable"})
+			return
+		}
+
+		c.Redirect(http.StatusFound, authURL)
+	}
+}
+
+// OIDCCallbackReq finishes the flow: it redeems the code, verifies the ID
+// token and logs in the linked user, linking or creating one if needed.
+func OIDCCallbackReq(stores store.Stores, client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provider, ok := authentication.GetOIDCProvider(c.Param("provider"))
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown login provider"})
+			return
+		}
+
+		if providerErr := c.Query("error"); providerErr != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Login was not completed: " + providerErr})
+			return
+		}
+
+		state, err := authentication.ConsumeOneTimeToken(client, authentication.PurposeOIDCState, c.Query("state"))
+		if err != nil || state.Data["provider"] != provider.Name {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired login state"})
+			return
+		}
+
+		idToken, err := provider.Exchange(c.Query("code"), state.Data["verifier"], state.Data["nonce"])
+		if err != nil {
+			log.Printf("OIDC login with %s failed: %v", provider.Name, err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to verify login with provider"})
+			return
+		}
+
+		user, status, msg := resolveOIDCUser(stores.Users, client, provider.Name, idToken)
+		if user == nil {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+
+		completeLogin(c, stores.Sessions, *user)
+	}
+}
+
+// resolveOIDCUser finds the user for an external identity. Unknown identities
+// are linked to the account with the same email, but only when the provider
+// verified that email and our account is verified too, so nobody can take
+// over an account by pre-registering or spoofing an address.
+func resolveOIDCUser(users store.UserStore, client *dynamodb.Client, providerName string, idToken *authentication.IDTokenClaims) (*amazon.User, int, string) {
+	identityID := amazon.IdentityID(providerName, idToken.Subject)
+
+	identity, err := amazon.GetIdentity(client, "identities", identityID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, "Failed to look up identity"
+	}
+	if identity != nil {
+		user, err := users.GetUser(identity.UserID)
+		if err != nil {
+			return nil, http.StatusUnauthorized, "Linked user no longer exists"
+		}
+		return user, http.StatusOK, ""
+	}
+
+	email := strings.ToLower(idToken.Email)
+	if email == "" || !idToken.EmailVerified {
+		return nil, http.StatusForbidden, "Provider did not supply a verified email address"
+	}
+
+	user, err := users.GetUserByEmail(email)
+	if err == nil {
+		if !user.IsEmailVerified() {
+			return nil, http.StatusConflict, "An unverified account with this email exists, verify it before linking"
+		}
+	} else {
+		if registrationInviteOnly() {
+			return nil, http.StatusForbidden, "Registration is by invitation only"
+		}
+		user, err = createOIDCUser(users, email, idToken.Name)
+		if err != nil {
+			return nil, http.StatusInternalServerError, "Failed to create user"
+		}
+	}
+
+	err = amazon.LinkIdentity(client, "identities", amazon.Identity{
+		ID:        identityID,
+		UserID:    user.ID,
+		Provider:  providerName,
+		Subject:   idToken.Subject,
+		Email:     email,
+		CreatedAt: time.Now().Unix(),
+	})
+	if err != nil {
+		return nil, http.StatusInternalServerError, "Failed to link identity"
+	}
+
+	return user, http.StatusOK, ""
+}
+
+// createOIDCUser registers a passwordless account whose email the provider
+// has already verified.
+func createOIDCUser(users store.UserStore, email, name string) (*amazon.User, error) {
+	if name == "" {
+		name = strings.Split(email, "@")[0]
+	}
+
+	user := amazon.User{
+		ID:          fmt.Sprintf("u_%s", ShortUUID()),
+		Name:        name,
+		Email:       email,
+		Roles:       authentication.DefaultRoles(email),
+		EmailStatus: amazon.EmailVerified,
+		CreatedAt:   time.Now().Unix(),
+	}
+	if err := users.CreateUser(user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
@@ -0,0 +1,456 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"xstudious-guide/amazon"
+	"xstudious-guide/authentication"
+	"xstudious-guide/email"
+	"xstudious-guide/store"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/gin-gonic/gin"
+	"github.com/resend/resend-go/v2"
+)
+
+const invitationTTL = 7 * 24 * time.Hour
+
+func CreateOrgReq(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A name is required"})
+			return
+		}
+
+		claims := authentication.GetClaims(c)
+		now := time.Now().Unix()
+
+		org := amazon.Organization{
+			ID:        fmt.Sprintf("o_%s", ShortUUID()),
+			Name:      strings.TrimSpace(req.Name),
+			CreatedBy: claims.ID,
+			CreatedAt: now,
+		}
+		if err := amazon.CreateOrganization(client, "organizations", org); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create organization"})
+			return
+		}
+
+		owner := amazon.Membership{OrgID: org.ID, UserID: claims.ID, Role: authentication.OrgRoleOwner, JoinedAt: now}
+		if err := amazon.AddMembership(client, "memberships", owner); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create organization"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message":      "Organization created!",
+			"organization": org,
+		})
+	}
+}
+
+func GetMyOrgsReq(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := authentication.GetClaims(c)
+
+		memberships, err := amazon.GetUserMemberships(client, "memberships", claims.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get organizations"})
+			return
+		}
+
+		orgs := []gin.H{}
+		for _, m := range memberships {
+			org, err := amazon.GetOrganization(client, "organizations", m.OrgID)
+			if err != nil {
+				continue
+			}
+			orgs = append(orgs, gin.H{
+				"organization": org,
+				"role":         m.Role,
+				"active":       m.OrgID == claims.OrgID,
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{"organizations": orgs})
+	}
+}
+
+// SwitchOrgReq returns an access token for the org given in the path, or for
+// the personal space with /orgs/personal/switch. The choice is stored on the
+// session so refreshed tokens keep it.
+func SwitchOrgReq(sessions store.SessionStore, client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := authentication.GetClaims(c)
+
+		var membership *amazon.Membership
+		if orgID := c.Param("id"); orgID != "personal" {
+			m, err := amazon.GetMembership(client, "memberships", orgID, claims.ID)
+			if err != nil || m == nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+				return
+			}
+			membership = m
+		}
+
+		switched := authentication.WithActiveOrg(*claims, membership)
+		if switched.SessionID != "" {
+			if err := sessions.SetSessionOrg(switched.SessionID, switched.OrgID); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to switch organization"})
+				return
+			}
+		}
+
+		accessToken, err := authentication.NewAccessToken(switched)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"accessToken": accessToken,
+			"orgId":       switched.OrgID,
+			"orgRole":     switched.OrgRole,
+		})
+	}
+}
+
+func GetOrgReq(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		org, err := amazon.GetOrganization(client, "organizations", c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"organization": org,
+			"role":         authentication.GetMembership(c).Role,
+		})
+	}
+}
+
+// GetOrgMembersReq is the org-scoped user listing: members see each other's
+// name and email, nothing about users outside the org.
+func GetOrgMembersReq(users store.UserStore, client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		memberships, err := amazon.GetOrgMembers(client, "memberships", c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get members"})
+			return
+		}
+
+		members := []gin.H{}
+		for _, m := range memberships {
+			user, err := users.GetUser(m.UserID)
+			if err != nil {
+				continue
+			}
+			members = append(members, gin.H{
+				"userId":      m.UserID,
+				"name":        user.Name,
+				"displayName": user.DisplayName,
+				"email":       user.Email,
+				"role":        m.Role,
+				"joinedAt":    m.JoinedAt,
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{"members": members})
+	}
+}
+
+// Only owners may create or touch other owners, and an org always keeps at
+// least one owner.
+func canManageMember(actor *amazon.Membership, targetRole, newRole string) bool {
+	if actor.Role == authentication.OrgRoleOwner {
+		return true
+	}
+	return actor.Role == authentication.OrgRoleAdmin &&
+		targetRole != authentication.OrgRoleOwner && newRole != authentication.OrgRoleOwner
+}
+
+func isLastOwner(client *dynamodb.Client, orgID string, target *amazon.Membership) (bool, error) {
+	if target.Role != authentication.OrgRoleOwner {
+		return false, nil
+	}
+
+	members, err := amazon.GetOrgMembers(client, "memberships", orgID)
+	if err != nil {
+		return false, err
+	}
+	owners := 0
+	for _, m := range members {
+		if m.Role == authentication.OrgRoleOwner {
+			owners++
+		}
+	}
+	return owners <= 1, nil
+}
+
+func UpdateOrgMemberReq(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Role string `json:"role"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || !authentication.IsOrgRole(req.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be owner, admin or member"})
+			return
+		}
+
+		orgID := c.Param("id")
+		actor := authentication.GetMembership(c)
+
+		target, err := amazon.GetMembership(client, "memberships", orgID, c.Param("userId"))
+		if err != nil || target == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+			return
+		}
+
+		if !canManageMember(actor, target.Role, req.Role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only owners can change owners"})
+			return
+		}
+
+		if req.Role != authentication.OrgRoleOwner {
+			last, err := isLastOwner(client, orgID, target)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check owners"})
+				return
+			}
+			if last {
+				c.JSON(http.StatusConflict, gin.H{"error": "An organization needs at least one owner"})
+				return
+			}
+		}
+
+		if err := amazon.UpdateMembershipRole(client, "memberships", orgID, target.UserID, req.Role); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update member"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Member updated!"})
+	}
+}
+
+// RemoveOrgMemberReq removes a member, or lets any member leave by removing
+// themselves.
+func RemoveOrgMemberReq(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID := c.Param("id")
+		actor := authentication.GetMembership(c)
+
+		target, err := amazon.GetMembership(client, "memberships", orgID, c.Param("userId"))
+		if err != nil || target == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+			return
+		}
+
+		if target.UserID != actor.UserID && !canManageMember(actor, target.Role, target.Role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed to remove this member"})
+			return
+		}
+
+		last, err := isLastOwner(client, orgID, target)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check owners"})
+			return
+		}
+		if last {
+			c.JSON(http.StatusConflict, gin.H{"error": "An organization needs at least one owner"})
+			return
+		}
+
+		if err := amazon.RemoveMembership(client, "memberships", orgID, target.UserID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove member"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Member removed!"})
+	}
+}
+
+func CreateInvitationReq(client *dynamodb.Client, emailClient *resend.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Email string `json:"email"`
+			Role  string `json:"role"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || req.Email == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "An email is required"})
+			return
+		}
+		if req.Role == "" {
+			req.Role = authentication.OrgRoleMember
+		}
+		if !authentication.IsOrgRole(req.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be owner, admin or member"})
+			return
+		}
+
+		claims := authentication.GetClaims(c)
+		actor := authentication.GetMembership(c)
+		if !canManageMember(actor, req.Role, req.Role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only owners can invite owners"})
+			return
+		}
+
+		org, err := amazon.GetOrganization(client, "organizations", c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+			return
+		}
+
+		now := time.Now()
+		inv := amazon.Invitation{
+			ID:        fmt.Sprintf("i_%s", ShortUUID()),
+			OrgID:     org.ID,
+			Email:     strings.ToLower(req.Email),
+			Role:      req.Role,
+			InvitedBy: claims.ID,
+			Status:    amazon.InvitationPending,
+			CreatedAt: now.Unix(),
+			ExpiresAt: now.Add(invitationTTL).Unix(),
+		}
+		if err := amazon.CreateInvitation(client, "invitations", inv); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invitation"})
+			return
+		}
+
+		go func() {
+			msg := email.OrgInvitationEmail(inv.Email, org.Name, claims.Name, email.AppURL("/invitations"))
+			if err := email.SendEmail(emailClient, msg); err != nil {
+				log.Printf("Failed to send invitation %s: %v", inv.ID, err)
+			}
+		}()
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message":    "Invitation sent!",
+			"invitation": inv,
+		})
+	}
+}
+
+func GetOrgInvitationsReq(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		invitations, err := amazon.GetOrgInvitations(client, "invitations", c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get invitations"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"invitations": invitations})
+	}
+}
+
+func RevokeInvitationReq(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		inv, err := amazon.GetInvitation(client, "invitations", c.Param("inviteId"))
+		if err != nil || inv.OrgID != c.Param("id") {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found"})
+			return
+		}
+
+		if err := amazon.CloseInvitation(client, "invitations", inv.ID, amazon.InvitationRevoked); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "Invitation is no longer pending"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Invitation revoked"})
+	}
+}
+
+// GetMyInvitationsReq lists pending invitations for the caller's email. The
+// email must be verified, otherwise anyone could claim invitations by
+// registering with someone else's address.
+func GetMyInvitationsReq(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := authentication.GetClaims(c)
+		if !claims.Verified {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Email address must be verified"})
+			return
+		}
+
+		all, err := amazon.GetInvitationsByEmail(client, "invitations", strings.ToLower(claims.Email))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get invitations"})
+			return
+		}
+
+		now := time.Now().Unix()
+		invitations := []gin.H{}
+		for _, inv := range all {
+			if inv.Status != amazon.InvitationPending || inv.ExpiresAt <= now {
+				continue
+			}
+			orgName := ""
+			if org, err := amazon.GetOrganization(client, "organizations", inv.OrgID); err == nil {
+				orgName = org.Name
+			}
+			invitations = append(invitations, gin.H{
+				"invitation":       inv,
+				"organizationName": orgName,
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{"invitations": invitations})
+	}
+}
+
+// RespondInvitationReq accepts or declines an invitation addressed to the
+// caller's verified email.
+func RespondInvitationReq(client *dynamodb.Client, accept bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := authentication.GetClaims(c)
+		if !claims.Verified {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Email address must be verified"})
+			return
+		}
+
+		inv, err := amazon.GetInvitation(client, "invitations", c.Param("id"))
+		if err != nil || !strings.EqualFold(inv.Email, claims.Email) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found"})
+			return
+		}
+
+		status := amazon.InvitationDeclined
+		if accept {
+			status = amazon.InvitationAccepted
+		}
+		if err := amazon.CloseInvitation(client, "invitations", inv.ID, status); err != nil {
+			if errors.Is(err, amazon.ErrInvitationClosed) {
+				c.JSON(http.StatusConflict, gin.H{"error": "Invitation is no longer pending"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update invitation"})
+			return
+		}
+
+		if !accept {
+			c.JSON(http.StatusOK, gin.H{"message": "Invitation declined"})
+			return
+		}
+
+		m := amazon.Membership{OrgID: inv.OrgID, UserID: claims.ID, Role: inv.Role, JoinedAt: time.Now().Unix()}
+		if err := amazon.AddMembership(client, "memberships", m); err != nil {
+			// already a member, keep the existing role
+			existing, getErr := amazon.GetMembership(client, "memberships", inv.OrgID, claims.ID)
+			if getErr != nil || existing == nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to join organization"})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Invitation accepted!",
+			"orgId":   inv.OrgID,
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"encoding/base64"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+// Page is the response envelope of every paginated list endpoint. Pass
+// NextCursor as ?cursor= to get the next page; it is absent on the last one.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"nextCursor,omitempty"`
+	HasMore    bool   `json:"hasMore"`
+}
+
+func newPage[T any](items []T, nextCursor string) Page[T] {
+	if items == nil {
+		items = []T{}
+	}
+	return Page[T]{Items: items, NextCursor: nextCursor, HasMore: nextCursor != ""}
+}
+
+// pageParams reads ?limit= and ?cursor=, answering 400 for a bad limit.
+func pageParams(c *gin.Context) (int, string, bool) {
+	limit := defaultPageSize
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxPageSize)})
+			return 0, "", false
+		}
+		limit = n
+	}
+	return limit, c.Query("cursor"), true
+}
+
+// pageSlice pages through a list that is already fully in memory, ordered by
+// key. The cursor is the encoded key of the last item returned.
+func pageSlice[T any](items []T, key func(T) string, limit int, cursor string) (Page[T], bool) {
+	after := ""
+	if cursor != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return Page[T]{}, false
+		}
+		after = string(raw)
+	}
+
+	sort.Slice(items, func(i, j int) bool { return key(items[i]) < key(items[j]) })
+	start := sort.Search(len(items), func(i int) bool { return key(items[i]) > after })
+	end := min(start+limit, len(items))
+
+	next := ""
+	if end < len(items) {
+		next = base64.RawURLEncoding.EncodeToString([]byte(key(items[end-1])))
+	}
+	return newPage(items[start:end], next), true
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"xstudious-guide/amazon"
+	"xstudious-guide/authentication"
+	"xstudious-guide/store"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/gin-gonic/gin"
+)
+
+type passkeyFinishRequest struct {
+	CeremonyToken string          `json:"ceremonyToken"`
+	Credential    json.RawMessage `json:"credential"`
+}
+
+func BeginPasskeyRegistrationReq(users store.UserStore, client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Name string `json:"name"`
+		}
+		// the name is optional, an empty body is fine
+		_ = c.ShouldBindJSON(&req)
+
+		claims := authentication.GetClaims(c)
+
+		user, err := users.GetUser(claims.ID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		options, ceremony, err := authentication.BeginPasskeyRegistration(client, *user, req.Name)
+		if err != nil {
+			log.Printf("Failed to begin passkey registration for %s: %v", user.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey registration"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"options":       options,
+			"ceremonyToken": ceremony,
+		})
+	}
+}
+
+func FinishPasskeyRegistrationReq(users store.UserStore, client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req passkeyFinishRequest
+		if err := c.ShouldBindJSON(&req); err != nil || req.CeremonyToken == "" || len(req.Credential) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		claims := authentication.GetClaims(c)
+
+		user, err := users.GetUser(claims.ID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		passkey, err := authentication.FinishPasskeyRegistration(client, *user, req.CeremonyToken, req.Credential)
+		if err != nil {
+			if errors.Is(err, amazon.ErrTokenInvalid) || errors.Is(err, authentication.ErrPasskeyInvalid) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Passkey registration failed or expired"})
+				return
+			}
+			log.Printf("Failed to register passkey for %s: %v", user.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save passkey"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "Passkey added!",
+			"passkey": passkey,
+		})
+	}
+}
+
+func GetPasskeysReq(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := authentication.GetClaims(c)
+
+		passkeys, err := amazon.GetUserPasskeys(client, "passkeys", claims.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get passkeys"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"passkeys": passkeys})
+	}
+}
+
+func RenamePasskeyReq(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || req.Name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A name is required"})
+			return
+		}
+
+		claims := authentication.GetClaims(c)
+
+		if err := amazon.RenamePasskey(client, "passkeys", claims.ID, c.Param("id"), req.Name); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Passkey not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Passkey renamed!"})
+	}
+}
+
+func DeletePasskeyReq(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := authentication.GetClaims(c)
+
+		if err := amazon.DeletePasskey(client, "passkeys", claims.ID, c.Param("id")); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Passkey not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Passkey removed!"})
+	}
+}
+
+func BeginPasskeyLoginReq(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		options, ceremony, err := authentication.BeginPasskeyLogin(client)
+		if err != nil {
+			log.Printf("Failed to begin passkey login: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey login"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"options":       options,
+			"ceremonyToken": ceremony,
+		})
+	}
+}
+
+// FinishPasskeyLoginReq answers like /login. No MFA challenge follows, the
+// assertion already required user verification on the authenticator.
+func FinishPasskeyLoginReq(stores store.Stores, client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req passkeyFinishRequest
+		if err := c.ShouldBindJSON(&req); err != nil || req.CeremonyToken == "" || len(req.Credential) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		user, err := authentication.FinishPasskeyLogin(client, stores.Users, req.CeremonyToken, req.Credential)
+		if err != nil {
+			if !errors.Is(err, amazon.ErrTokenInvalid) && !errors.Is(err, authentication.ErrPasskeyInvalid) {
+				log.Printf("Passkey login failed: %v", err)
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Passkey could not be verified"})
+			return
+		}
+
+		issueLoginTokens(c, stores.Sessions, *user)
+	}
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+	"xstudious-guide/amazon"
+	"xstudious-guide/authentication"
+	"xstudious-guide/email"
+	"xstudious-guide/store"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/gin-gonic/gin"
+	"github.com/resend/resend-go/v2"
+)
+
+const (
+	passwordResetTTL      = 30 * time.Minute
+	passwordResetCooldown = time.Minute
+)
+
+// rejectInvalidPassword answers 400 with every broken policy rule, the same
+// way for registration, password change and reset.
+func rejectInvalidPassword(c *gin.Context, password, name, email string) bool {
+	err := authentication.ValidatePassword(password, name, email)
+	if err == nil {
+		return false
+	}
+
+	var policyErr *authentication.PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    "Password does not meet the password policy",
+			"problems": policyErr.Problems,
+		})
+		return true
+	}
+
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid password"})
+	return true
+}
+
+// rehashPassword replaces a hash made with an outdated scheme or cost once
+// the plain password is known, i.e. right after a successful login.
+func rehashPassword(users store.UserStore, user amazon.User, password string) {
+	if !authentication.NeedsRehash(user.Password) {
+		return
+	}
+
+	hashed, err := authentication.HashedPassword(password)
+	if err != nil {
+		log.Printf("Failed to rehash password for %s: %v", user.ID, err)
+		return
+	}
+
+	if err := users.UpdatePassword(user.ID, hashed); err != nil {
+		log.Printf("Failed to store rehashed password for %s: %v", user.ID, err)
+	}
+}
+
+func ForgotPasswordReq(users store.UserStore, client *dynamodb.Client, emailClient *resend.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Email string `json:"email"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || req.Email == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		// The lookup and send happen in the background so neither the response
+		// nor its timing reveals whether the address has an account.
+		go func(address string) {
+			user, err := users.GetUserByEmail(address)
+			if err != nil {
+				return
+			}
+
+			// at most one link a minute per account, like /login/magic
+			now := time.Now()
+			if err := users.ClaimResetSend(user.ID, now.Unix(), now.Add(-passwordResetCooldown).Unix()); err != nil {
+				if !errors.Is(err, amazon.ErrThrottled) {
+					log.Printf("Failed to record reset email for %s: %v", user.ID, err)
+				}
+				return
+			}
+
+			token, err := authentication.NewOneTimeToken(client, authentication.PurposePasswordReset, user.ID, user.Email, passwordResetTTL)
+			if err != nil {
+				log.Printf("Failed to create reset token for %s: %v", user.ID, err)
+				return
+			}
+
+			link := email.AppURL("/password/reset?token=" + url.QueryEscape(token))
+			if err := email.SendEmail(emailClient, email.PasswordResetEmail(user.Email, user.Name, link)); err != nil {
+				log.Printf("Failed to send reset email to %s: %v", user.ID, err)
+			}
+		}(req.Email)
+
+		c.JSON(http.StatusOK, gin.H{"message": "If an account exists for that email, a reset link has been sent"})
+	}
+}
+
+// ResetPasswordPageReq serves the page the emailed reset link opens. The
+// form POSTs the token and the new password to ResetPasswordReq.
+func ResetPasswordPageReq(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, err := authentication.PeekOneTimeToken(client, authentication.PurposePasswordReset, c.Query("token")); err != nil {
+			linkErrorPage(c, "Reset your password", "This reset link is invalid or has expired. You can ask for a new one.")
+			return
+		}
+		linkConfirmPage(c, "Reset your password", "Choose a new password for your account.", "Reset password",
+			linkField{Name: "newPassword", Label: "New password", Type: "password"})
+	}
+}
+
+// ResetPasswordReq takes JSON from API clients and the form of
+// ResetPasswordPageReq.
+func ResetPasswordReq(stores store.Stores, client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Token       string `form:"token" json:"token"`
+			NewPassword string `form:"newPassword" json:"newPassword"`
+		}
+		if err := c.ShouldBind(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if req.Token == "" || req.NewPassword == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing token or new password"})
+			return
+		}
+
+		// a rejected password must not burn the link, so the token is only
+		// consumed once the new password is known to be acceptable
+		token, err := authentication.PeekOneTimeToken(client, authentication.PurposePasswordReset, req.Token)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset link"})
+			return
+		}
+
+		user, err := stores.Users.GetUser(token.UserID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset link"})
+			return
+		}
+
+		if user.Email != token.Email {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset link"})
+			return
+		}
+
+		if rejectInvalidPassword(c, req.NewPassword, user.Name, user.Email) {
+			return
+		}
+
+		hashedPassword, err := authentication.HashedPassword(req.NewPassword)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash new password"})
+			return
+		}
+
+		if _, err := authentication.ConsumeOneTimeToken(client, authentication.PurposePasswordReset, req.Token); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset link"})
+			return
+		}
+
+		if err := stores.Users.UpdatePassword(user.ID, hashedPassword); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+			return
+		}
+
+		// whoever knew the old password must not stay logged in
+		if err := stores.Sessions.RevokeUserSessions(user.ID); err != nil {
+			log.Printf("Failed to revoke sessions for %s after password reset: %v", user.ID, err)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Password has been reset, please log in again"})
+	}
+}
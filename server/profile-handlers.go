@@ -0,0 +1,195 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	_ "time/tzdata" // validate timezones without relying on the host's zoneinfo
+	"unicode/utf8"
+	"xstudious-guide/amazon"
+	"xstudious-guide/authentication"
+	"xstudious-guide/store"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxDisplayNameLength = 64
+	maxBioLength         = 500
+	maxPreferencesSize   = 4096 // bytes of JSON
+	maxPreferenceKey     = 64
+)
+
+// loose BCP 47 check, e.g. "en", "en-GB", "zh-Hant-TW"
+var localePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+type profilePatch struct {
+	Name        *string                `json:"name"`
+	DisplayName *string                `json:"displayName"`
+	Bio         *string                `json:"bio"`
+	Timezone    *string                `json:"timezone"`
+	Locale      *string                `json:"locale"`
+	Preferences map[string]interface{} `json:"preferences"` // merged, null removes a key
+}
+
+func GetMeReq(users store.UserStore, presigner *s3.PresignClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := authentication.GetClaims(c)
+
+		user, err := users.GetUser(claims.ID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		c.Header("ETag", userETag(user.Version))
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Profile Found!",
+			"profile": profileResponse(presigner, user),
+		})
+	}
+}
+
+func UpdateMeReq(users store.UserStore, presigner *s3.PresignClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req profilePatch
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		if problems := validateProfilePatch(req); len(problems) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid profile", "problems": problems})
+			return
+		}
+
+		claims := authentication.GetClaims(c)
+
+		user, err := users.GetUser(claims.ID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if !ifMatch(c, user.Version) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": "User has changed, fetch it again"})
+			return
+		}
+
+		update := amazon.ProfileUpdate{
+			Name:        req.Name,
+			DisplayName: req.DisplayName,
+			Bio:         req.Bio,
+			Timezone:    req.Timezone,
+			Locale:      req.Locale,
+		}
+
+		if req.Preferences != nil {
+			merged := mergePreferences(user.Preferences, req.Preferences)
+			if raw, _ := json.Marshal(merged); len(raw) > maxPreferencesSize {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Preferences are too large"})
+				return
+			}
+			update.Preferences = merged
+		}
+
+		if err := users.UpdateProfile(claims.ID, update, user.Version); err != nil {
+			switch {
+			case errors.Is(err, amazon.ErrNothingToUpdate):
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to update"})
+			case errors.Is(err, amazon.ErrVersionConflict):
+				c.JSON(http.StatusPreconditionFailed, gin.H{"error": "User has changed, fetch it again"})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+			}
+			return
+		}
+
+		user, err = users.GetUser(claims.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+			return
+		}
+
+		c.Header("ETag", userETag(user.Version))
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Profile Updated!",
+			"profile": profileResponse(presigner, user),
+		})
+	}
+}
+
+func validateProfilePatch(req profilePatch) []string {
+	var problems []string
+
+	if req.Name != nil && strings.TrimSpace(*req.Name) == "" {
+		problems = append(problems, "name cannot be empty")
+	}
+	if req.DisplayName != nil && utf8.RuneCountInString(*req.DisplayName) > maxDisplayNameLength {
+		problems = append(problems, "displayName is too long")
+	}
+	if req.Bio != nil && utf8.RuneCountInString(*req.Bio) > maxBioLength {
+		problems = append(problems, "bio is too long")
+	}
+	if req.Timezone != nil && *req.Timezone != "" {
+		if _, err := time.LoadLocation(*req.Timezone); err != nil || *req.Timezone == "Local" {
+			problems = append(problems, "timezone must be an IANA name such as Europe/Berlin")
+		}
+	}
+	if req.Locale != nil && *req.Locale != "" && !localePattern.MatchString(*req.Locale) {
+		problems = append(problems, "locale must be a language tag such as en-GB")
+	}
+	for key := range req.Preferences {
+		if key == "" || len(key) > maxPreferenceKey {
+			problems = append(problems, "preference keys must be 1 to 64 characters")
+			break
+		}
+	}
+
+	return problems
+}
+
+// mergePreferences applies patch on top of current in the style of a JSON
+// merge patch: keys are replaced as a whole and a null value removes a key.
+func mergePreferences(current, patch map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(current)+len(patch))
+	for k, v := range current {
+		merged[k] = v
+	}
+	for k, v := range patch {
+		if v == nil {
+			delete(merged, k)
+		} else {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+func profileResponse(presigner *s3.PresignClient, user *amazon.User) UserResponse {
+	return userResponseWithAvatar(presigner, *user, visibilitySelf)
+}
+
+// userResponseWithAvatar is newUserResponse with a short-lived link to the
+// avatar. The avatar is part of every view, the S3 key itself is never shown.
+func userResponseWithAvatar(presigner *s3.PresignClient, user amazon.User, visibility userVisibility) UserResponse {
+	resp := newUserResponse(user, visibility)
+
+	if user.AvatarKey != "" {
+		req, err := presigner.PresignGetObject(context.TODO(), &s3.GetObjectInput{
+			Bucket: aws.String(os.Getenv("AWS_BUCKET")),
+			Key:    aws.String(user.AvatarKey),
+		}, s3.WithPresignExpires(15*time.Minute))
+		if err == nil {
+			resp.AvatarURL = req.URL
+		}
+	}
+
+	return resp
+}
@@ -0,0 +1,251 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+	"xstudious-guide/amazon"
+	"xstudious-guide/authentication"
+	"xstudious-guide/store"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	defaultDeletionGraceDays = 30
+	purgeInterval            = time.Hour
+)
+
+// deletionGracePeriod is how long a deleted account can be restored,
+// ACCOUNT_DELETION_GRACE_DAYS (default 30, 0 purges on the next run).
+func deletionGracePeriod() time.Duration {
+	days := defaultDeletionGraceDays
+	if raw := os.Getenv("ACCOUNT_DELETION_GRACE_DAYS"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n >= 0 {
+			days = n
+		} else {
+			log.Printf("Ignoring invalid ACCOUNT_DELETION_GRACE_DAYS %q", raw)
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// StartPurgeJob removes deleted accounts whose grace period has ended, once
+// at startup and then every hour. It needs S3 to delete the users' objects.
+func StartPurgeJob(stores store.Stores, dynamo *dynamodb.Client, s3client *s3.Client) {
+	if dynamo == nil || s3client == nil {
+		log.Printf("Account purge job disabled, DynamoDB and S3 are both required")
+		return
+	}
+
+	go func() {
+		for {
+			purgeDeletedUsers(stores, dynamo, s3client)
+			time.Sleep(purgeInterval)
+		}
+	}()
+}
+
+func purgeDeletedUsers(stores store.Stores, dynamo *dynamodb.Client, s3client *s3.Client) {
+	users, err := stores.Users.UsersDueForPurge(time.Now().Unix())
+	if err != nil {
+		log.Printf("Account purge failed: %v", err)
+		return
+	}
+
+	for _, user := range users {
+		if _, err := purgeUser(stores, dynamo, s3client, user); err != nil {
+			// the user row goes last, so the next run picks it up again
+			log.Printf("Failed to purge user %s: %v", user.ID, err)
+			continue
+		}
+
+		ref := erasedUserRef(user.ID)
+		event := amazon.AuditEvent{
+			Action:   authentication.AuditUserPurge,
+			ActorID:  "system",
+			TargetID: ref,
+		}
+		if err := authentication.RecordAudit(dynamo, event); err != nil {
+			log.Printf("Failed to audit purge of %s: %v", ref, err)
+		}
+		log.Printf("Purged user %s", ref)
+	}
+}
+
+// erasureSummary counts what purgeUser removed, and what it kept.
+type erasureSummary struct {
+	Files       int
+	OrgFiles    int
+	Sessions    int
+	APIKeys     int
+	Passkeys    int
+	Identities  int
+	Memberships int
+	Invitations int
+	Tokens      int
+	Exports     int
+	AuditEvents int
+}
+
+func (s erasureSummary) erased() []string {
+	return []string{
+		"profile, preferences and avatar",
+		fmt.Sprintf("%d personal files", s.Files),
+		fmt.Sprintf("%d sessions", s.Sessions),
+		fmt.Sprintf("%d API keys", s.APIKeys),
+		fmt.Sprintf("%d passkeys", s.Passkeys),
+		fmt.Sprintf("%d linked identities", s.Identities),
+		fmt.Sprintf("%d organization memberships", s.Memberships),
+		fmt.Sprintf("%d organization invitations", s.Invitations),
+		fmt.Sprintf("%d emailed links and invites", s.Tokens),
+		"failed login history",
+		fmt.Sprintf("%d data exports", s.Exports),
+	}
+}
+
+func (s erasureSummary) retained() []string {
+	var retained []string
+	if s.OrgFiles > 0 {
+		retained = append(retained, fmt.Sprintf("%d files uploaded to organizations, which own them", s.OrgFiles))
+	}
+	if s.AuditEvents > 0 {
+		retained = append(retained, fmt.Sprintf("%d audit events, pseudonymized", s.AuditEvents))
+	}
+	return retained
+}
+
+// erasedUserRef is the pseudonym that replaces an erased user's ID in the
+// audit log. It links the events together without naming the account.
+func erasedUserRef(userID string) string {
+	sum := sha256.Sum256([]byte(userID))
+	return "erased:" + hex.EncodeToString(sum[:8])
+}
+
+// purgeUser deletes everything stored for a user and pseudonymizes the audit
+// events about them. Files uploaded into an org belong to the org and are
+// kept. Every step can be repeated, so a failed purge is retried as a whole.
+func purgeUser(stores store.Stores, dynamo *dynamodb.Client, s3client *s3.Client, user amazon.User) (erasureSummary, error) {
+	var summary erasureSummary
+
+	files, err := stores.Files.GetUserFiles(user.ID)
+	if err != nil {
+		return summary, fmt.Errorf("listing files: %w", err)
+	}
+	for _, f := range files {
+		if f.OrgID != "" {
+			summary.OrgFiles++
+			continue
+		}
+		if err := amazon.DeleteFile(s3client, f.FileKey); err != nil {
+			return summary, err
+		}
+		if err := stores.Files.DeleteFile(f.UserID, f.FileID); err != nil {
+			return summary, err
+		}
+		summary.Files++
+	}
+
+	if user.AvatarKey != "" {
+		if err := amazon.DeleteFile(s3client, user.AvatarKey); err != nil {
+			return summary, err
+		}
+	}
+
+	summary.Sessions, err = stores.Sessions.DeleteUserSessions(user.ID)
+	if err != nil {
+		return summary, err
+	}
+
+	keys, err := stores.APIKeys.GetUserAPIKeys(user.ID)
+	if err != nil {
+		return summary, fmt.Errorf("listing api keys: %w", err)
+	}
+	for _, k := range keys {
+		if err := stores.APIKeys.DeleteAPIKey(k.ID); err != nil {
+			return summary, err
+		}
+	}
+	summary.APIKeys = len(keys)
+
+	passkeys, err := amazon.GetUserPasskeys(dynamo, "passkeys", user.ID)
+	if err != nil {
+		return summary, fmt.Errorf("listing passkeys: %w", err)
+	}
+	for _, p := range passkeys {
+		if err := amazon.DeletePasskey(dynamo, "passkeys", user.ID, p.ID); err != nil {
+			return summary, err
+		}
+	}
+	summary.Passkeys = len(passkeys)
+
+	identities, err := amazon.GetUserIdentities(dynamo, "identities", user.ID)
+	if err != nil {
+		return summary, err
+	}
+	for _, i := range identities {
+		if err := amazon.DeleteIdentity(dynamo, "identities", i.ID); err != nil {
+			return summary, err
+		}
+	}
+	summary.Identities = len(identities)
+
+	memberships, err := amazon.GetUserMemberships(dynamo, "memberships", user.ID)
+	if err != nil {
+		return summary, fmt.Errorf("listing memberships: %w", err)
+	}
+	for _, m := range memberships {
+		if err := amazon.RemoveMembership(dynamo, "memberships", m.OrgID, user.ID); err != nil {
+			return summary, err
+		}
+	}
+	summary.Memberships = len(memberships)
+
+	invitations, err := amazon.GetInvitationsByEmail(dynamo, "invitations", user.Email)
+	if err != nil {
+		return summary, fmt.Errorf("listing invitations: %w", err)
+	}
+	for _, inv := range invitations {
+		if err := amazon.DeleteInvitation(dynamo, "invitations", inv.ID); err != nil {
+			return summary, err
+		}
+	}
+	summary.Invitations = len(invitations)
+
+	summary.Tokens, err = amazon.DeleteUserTokens(dynamo, "tokens", user.ID, user.Email)
+	if err != nil {
+		return summary, err
+	}
+
+	if err := authentication.ClearFailedLogins(stores.LoginAttempts, user.Email); err != nil {
+		return summary, fmt.Errorf("clearing login attempts: %w", err)
+	}
+
+	// events the user performed on others keep their actor ID, the log has no
+	// index to find them and they are about the other account. Events on
+	// their own account lose it with the target.
+	events, err := amazon.GetAuditEventsForTarget(dynamo, "audit_log", user.ID)
+	if err != nil {
+		return summary, fmt.Errorf("listing audit events: %w", err)
+	}
+	ref := erasedUserRef(user.ID)
+	for _, e := range events {
+		if key := e.Details["fileKey"]; e.Action == authentication.AuditUserExport && key != "" {
+			if err := amazon.DeleteFile(s3client, key); err != nil {
+				return summary, err
+			}
+			summary.Exports++
+		}
+		if err := amazon.PseudonymizeAuditEvent(dynamo, "audit_log", e, ref); err != nil {
+			return summary, err
+		}
+	}
+	summary.AuditEvents = len(events)
+
+	return summary, stores.Users.DeleteUser(user.ID)
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"xstudious-guide/authentication"
+	"xstudious-guide/store"
 
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
@@ -11,32 +12,165 @@ import (
 	"googlemaps.github.io/maps"
 )
 
-func AddDynamoDBRoutes(client *dynamodb.Client, r *gin.Engine) {
-	r.POST("/register", CreateNewUserReq(client))
-	r.POST("/login", AuthUserReq(client))
-	r.POST("/refresh-token", authentication.RefreshTokenHandler(client))
+// NewDynamoStores returns the stores backed by the DynamoDB tables that
+// ConnectDB creates.
+func NewDynamoStores(client *dynamodb.Client) store.Stores {
+	return store.Stores{
+		Users:         store.NewDynamoUserStore(client, "users"),
+		Files:         store.NewDynamoFileStore(client, "files"),
+		Sessions:      store.NewDynamoSessionStore(client, "sessions"),
+		LoginAttempts: store.NewDynamoLoginAttemptStore(client, "login_attempts"),
+		APIKeys:       store.NewDynamoAPIKeyStore(client, "api_keys"),
+	}
+}
+
+// AddDynamoDBRoutes only uses presigner for avatar links in user responses.
+func AddDynamoDBRoutes(client *dynamodb.Client, presigner *s3.PresignClient, stores store.Stores, emailClient *resend.Client, r *gin.Engine) {
+	users := stores.Users
+
+	r.GET("/register", RegisterPageReq(client))
+	r.POST("/register", CreateNewUserReq(stores, client, emailClient))
+	r.POST("/login", AuthUserReq(stores, emailClient))
+	r.POST("/login/mfa", LoginMFAReq(stores, emailClient))
+	r.POST("/login/magic", MagicLinkReq(users, client, emailClient))
+	r.GET("/login/magic/callback", MagicLinkPageReq(client))
+	r.POST("/login/magic/callback", MagicLinkCallbackReq(stores, client))
+	r.POST("/login/passkey/begin", BeginPasskeyLoginReq(client))
+	r.POST("/login/passkey/finish", FinishPasskeyLoginReq(stores, client))
+	r.GET("/login/oidc/:provider", OIDCLoginReq(client))
+	r.GET("/login/oidc/:provider/callback", OIDCCallbackReq(stores, client))
+	r.POST("/refresh-token", authentication.RefreshTokenHandler(client, users, stores.Sessions))
+	r.POST("/logout", authentication.LogoutHandler(stores.Sessions))
+	r.GET("/verify-email", VerifyEmailPageReq(client))
+	r.POST("/verify-email", VerifyEmailReq(users, client))
+	r.POST("/password/forgot", ForgotPasswordReq(users, client, emailClient))
+	r.GET("/password/reset", ResetPasswordPageReq(client))
+	r.POST("/password/reset", ResetPasswordReq(stores, client))
+	r.POST("/oauth/token", ClientCredentialsTokenReq(client))
+	r.POST("/erasure-receipts/verify", VerifyErasureReceiptReq())
+
+	// user and account routes are for people, service clients use the
+	// file, email, maps and AI routes below
+	auth := r.Group("/", authentication.AuthMiddleware(client, stores), authentication.RequireUser())
+	{
+		auth.POST("/tokens/scoped", authentication.DenyAPIKeys(), MintScopedTokenReq())
+	}
+
+	read := auth.Group("/", authentication.RequireScope(authentication.PermUsersRead))
+	{
+		read.GET("/users/:id", authentication.RequirePermission(authentication.PermUsersRead), GetUserByIDReq(users, presigner))
+		read.GET("/me/sessions", authentication.DenyAPIKeys(), GetMySessionsReq(stores.Sessions))
+		read.GET("/orgs", GetMyOrgsReq(client))
+		read.GET("/orgs/:id", authentication.RequireOrgRole(client), GetOrgReq(client))
+		read.GET("/orgs/:id/members", authentication.RequireOrgRole(client), GetOrgMembersReq(users, client))
+		read.GET("/orgs/:id/invitations", authentication.RequireOrgRole(client, authentication.OrgRoleOwner, authentication.OrgRoleAdmin), GetOrgInvitationsReq(client))
+		read.GET("/me/invitations", GetMyInvitationsReq(client))
+	}
+
+	// account management, also unavailable to tokens without users:write
+	write := auth.Group("/", authentication.RequireScope(authentication.PermUsersWrite))
+	{
+		write.PUT("/users", authentication.DenyImpersonation(), authentication.RequirePermission(authentication.PermUsersWrite), UpdateUserReq(users, client, emailClient))
+		write.POST("/logout-all", authentication.DenyImpersonation(), authentication.LogoutAllHandler(stores.Sessions))
+		write.POST("/verify-email/resend", ResendVerificationReq(users, client, emailClient))
+		write.POST("/orgs/:id/switch", authentication.DenyAPIKeys(), SwitchOrgReq(stores.Sessions, client))
+	}
+
+	orgs := write.Group("/", authentication.DenyImpersonation())
+	{
+		orgs.POST("/orgs", CreateOrgReq(client))
+		orgs.PUT("/orgs/:id/members/:userId", authentication.RequireOrgRole(client, authentication.OrgRoleOwner, authentication.OrgRoleAdmin), UpdateOrgMemberReq(client))
+		orgs.DELETE("/orgs/:id/members/:userId", authentication.RequireOrgRole(client), RemoveOrgMemberReq(client))
+		orgs.POST("/orgs/:id/invitations", authentication.RequireOrgRole(client, authentication.OrgRoleOwner, authentication.OrgRoleAdmin), CreateInvitationReq(client, emailClient))
+		orgs.DELETE("/orgs/:id/invitations/:inviteId", authentication.RequireOrgRole(client, authentication.OrgRoleOwner, authentication.OrgRoleAdmin), RevokeInvitationReq(client))
+		orgs.POST("/invitations/:id/accept", RespondInvitationReq(client, true))
+		orgs.POST("/invitations/:id/decline", RespondInvitationReq(client, false))
+	}
+
+	// credentials need an interactive login by the user themselves
+	creds := write.Group("/", authentication.DenyAPIKeys(), authentication.DenyImpersonation())
+	{
+		creds.DELETE("/me/sessions/:id", RevokeMySessionReq(stores.Sessions))
+		creds.PUT("/users/password", authentication.RequirePermission(authentication.PermUsersWrite), UpdatePasswordReq(users))
+		creds.POST("/mfa/totp/enroll", EnrollTOTPReq(users))
+		creds.POST("/mfa/totp/confirm", ConfirmTOTPReq(users))
+		creds.POST("/mfa/totp/disable", DisableTOTPReq(users))
+		creds.POST("/api-keys", CreateAPIKeyReq(stores.APIKeys))
+		creds.GET("/api-keys", GetAPIKeysReq(stores.APIKeys))
+		creds.DELETE("/api-keys/:id", RevokeAPIKeyReq(stores.APIKeys))
+		creds.POST("/passkeys/register/begin", BeginPasskeyRegistrationReq(users, client))
+		creds.POST("/passkeys/register/finish", FinishPasskeyRegistrationReq(users, client))
+		creds.GET("/passkeys", GetPasskeysReq(client))
+		creds.PATCH("/passkeys/:id", RenamePasskeyReq(client))
+		creds.DELETE("/passkeys/:id", DeletePasskeyReq(client))
+	}
 
-	auth := r.Group("/", authentication.AuthMiddleware())
+	del := auth.Group("/", authentication.RequireScope(authentication.PermUsersDelete), authentication.DenyImpersonation())
 	{
-		auth.GET("/users", GetAllUsersReq(client))
-		auth.GET("/users/:id", GetUserByIDReq(client))
-		auth.PUT("/users", UpdateUserReq(client))
-		auth.PUT("/users/password", UpdatePasswordReq(client))
-		auth.DELETE("/users/:id", DeleteUserReq(client))
+		del.DELETE("/users/:id", authentication.RequirePermission(authentication.PermUsersDelete), DeleteUserReq(stores, client))
+	}
+
+	admin := auth.Group("/", authentication.RequireScope(authentication.PermUsersAdmin), authentication.RequirePermission(authentication.PermUsersAdmin))
+	{
+		admin.GET("/users", GetAllUsersReq(users, presigner))
+		admin.PUT("/users/:id/roles", UpdateUserRolesReq(users))
+		admin.POST("/admin/users/:id/unlock", UnlockUserReq(users, stores.LoginAttempts))
+		admin.POST("/admin/users/:id/deactivate", authentication.DenyImpersonation(), DeactivateUserReq(stores, client))
+		admin.POST("/admin/users/:id/reactivate", authentication.DenyImpersonation(), ReactivateUserReq(stores, client))
+		admin.POST("/admin/users/:id/restore", authentication.DenyImpersonation(), RestoreUserReq(stores, client))
+		admin.POST("/admin/invites", authentication.DenyAPIKeys(), authentication.DenyImpersonation(), CreateInviteReq(users, client, emailClient))
+		admin.POST("/admin/impersonate/:id", authentication.DenyAPIKeys(), ImpersonateUserReq(stores, client))
+		admin.DELETE("/admin/impersonations/:id", authentication.DenyAPIKeys(), EndImpersonationReq(stores.Sessions, client))
+		admin.GET("/admin/users/:id/audit", GetUserAuditLogReq(client))
+		admin.POST("/admin/clients", authentication.DenyAPIKeys(), authentication.DenyImpersonation(), CreateServiceClientReq(client))
+		admin.GET("/admin/clients", GetServiceClientsReq(client))
+		admin.DELETE("/admin/clients/:id", authentication.DenyAPIKeys(), authentication.DenyImpersonation(), RevokeServiceClientReq(client))
 	}
 }
 
-func AddS3Routes(s3client *s3.Client, dynamoclient *dynamodb.Client, r *gin.Engine) {
-	auth := r.Group("/", authentication.AuthMiddleware())
+func AddS3Routes(s3client *s3.Client, dynamoclient *dynamodb.Client, stores store.Stores, emailClient *resend.Client, r *gin.Engine) {
+	users := stores.Users
+	files := stores.Files
+
+	auth := r.Group("/", authentication.AuthMiddleware(dynamoclient, stores))
+
+	read := auth.Group("/", authentication.RequireScope(authentication.PermFilesRead), authentication.RequirePermission(authentication.PermFilesRead))
+	{
+		read.GET("/files", GetUserFilesHandler(files, s3.NewPresignClient(s3client)))
+		read.GET("/download", Download(s3client, files))
+	}
+
+	write := auth.Group("/", authentication.RequireScope(authentication.PermFilesWrite), authentication.RequirePermission(authentication.PermFilesWrite))
+	{
+		write.POST("/upload", authentication.RequireVerifiedEmail(), Upload(s3client, files))
+	}
+
+	// profiles sit with the file routes because avatars are stored in S3
+	presigner := s3.NewPresignClient(s3client)
+	profile := auth.Group("/", authentication.RequireUser())
+	{
+		profile.GET("/me", authentication.RequireScope(authentication.PermUsersRead), GetMeReq(users, presigner))
+	}
+
+	profileWrite := profile.Group("/", authentication.RequireScope(authentication.PermUsersWrite), authentication.DenyImpersonation())
+	{
+		profileWrite.PATCH("/me", UpdateMeReq(users, presigner))
+		profileWrite.POST("/me/avatar", UploadAvatarReq(s3client, users))
+		profileWrite.DELETE("/me/avatar", DeleteAvatarReq(s3client, users))
+		profileWrite.POST("/me/export", authentication.DenyAPIKeys(), ExportMyDataReq(stores, dynamoclient, s3client, emailClient))
+	}
+
+	// erasure removes S3 objects too, so it is registered here rather than
+	// with the soft delete in AddDynamoDBRoutes
+	erase := auth.Group("/", authentication.RequireUser(), authentication.DenyAPIKeys(), authentication.DenyImpersonation())
 	{
-		auth.POST("/upload", Upload(s3client, dynamoclient))
-		auth.GET("/files", GetUserFilesHandler(dynamoclient, s3.NewPresignClient(s3client)))
-		auth.GET("/download", Download(s3client))
+		erase.POST("/me/erasure", authentication.RequireScope(authentication.PermUsersDelete), authentication.RequirePermission(authentication.PermUsersDelete), EraseMeReq(stores, dynamoclient, s3client, emailClient))
+		erase.POST("/admin/users/:id/erase", authentication.RequireScope(authentication.PermUsersAdmin), authentication.RequirePermission(authentication.PermUsersAdmin), EraseUserReq(stores, dynamoclient, s3client, emailClient))
 	}
 }
 
-func AddMapRoutes(client *maps.Client, r *gin.Engine) {
-	auth := r.Group("/", authentication.AuthMiddleware())
+func AddMapRoutes(client *maps.Client, dynamoclient *dynamodb.Client, stores store.Stores, r *gin.Engine) {
+	auth := r.Group("/", authentication.AuthMiddleware(dynamoclient, stores), authentication.RequireScope(authentication.PermMapsRead), authentication.RequirePermission(authentication.PermMapsRead))
 	{
 		auth.GET("/geocode", Geocode(client))
 		auth.GET("/reverse-geocode", ReverseGeocode(client))
@@ -44,16 +178,16 @@ func AddMapRoutes(client *maps.Client, r *gin.Engine) {
 	}
 }
 
-func AddAIROutes(client *openai.Client, r *gin.Engine) {
-	auth := r.Group("/", authentication.AuthMiddleware())
+func AddAIROutes(client *openai.Client, dynamoclient *dynamodb.Client, stores store.Stores, r *gin.Engine) {
+	auth := r.Group("/", authentication.AuthMiddleware(dynamoclient, stores), authentication.RequireScope(authentication.PermAIPrompt), authentication.RequirePermission(authentication.PermAIPrompt))
 	{
 		auth.POST("/ai/basic", SendBasicPrompt(client))
 	}
 }
 
-func AddEmailRoutes(client *resend.Client, r *gin.Engine) {
-	auth := r.Group("/", authentication.AuthMiddleware())
+func AddEmailRoutes(client *resend.Client, dynamoclient *dynamodb.Client, stores store.Stores, r *gin.Engine) {
+	auth := r.Group("/", authentication.AuthMiddleware(dynamoclient, stores), authentication.RequireScope(authentication.PermEmailSend), authentication.RequirePermission(authentication.PermEmailSend))
 	{
-		auth.POST("/send-email", SendEmailHandler(client))
+		auth.POST("/send-email", authentication.RequireVerifiedEmail(), SendEmailHandler(client))
 	}
 }
@@ -4,38 +4,48 @@ import (
 	"log"
 	"xstudious-guide/ai"
 	"xstudious-guide/amazon"
+	"xstudious-guide/authentication"
 	"xstudious-guide/email"
 	location "xstudious-guide/maps"
 
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/gin-gonic/gin"
 )
 
 func InitServer() {
 	go hub.Run()
 
+	authentication.InitAuth()
+	authentication.LoadCustomRoles()
+
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.Default()
 
+	// connect with Resend
+	emailClient, emailStatus := email.InitEmail()
+
+	// connect S3 first, user responses link to avatars
+	s3Client, s3Status := amazon.ConnectS3()
+
 	// connect DynamoDB
 	dynamoClient, dynamodbStatus := amazon.ConnectDB()
-	AddDynamoDBRoutes(dynamoClient, router)
+	stores := NewDynamoStores(dynamoClient)
+	AddDynamoDBRoutes(dynamoClient, s3.NewPresignClient(s3Client), stores, emailClient, router)
 
-	// connect S3
-	s3Client, s3Status := amazon.ConnectS3()
-	AddS3Routes(s3Client, dynamoClient, router)
+	AddS3Routes(s3Client, dynamoClient, stores, emailClient, router)
+	StartPurgeJob(stores, dynamoClient, s3Client)
 
 	// connect Google Maps
 	mapClient, mapsStatus := location.InitMaps()
-	AddMapRoutes(mapClient, router)
+	AddMapRoutes(mapClient, dynamoClient, stores, router)
 
 	// connect with OpenAI
 	aiClient, openAIStatus := ai.InitAi()
-	AddAIROutes(aiClient, router)
+	AddAIROutes(aiClient, dynamoClient, stores, router)
 
-	// commect with Resend
-	emailClient, emailStatus := email.InitEmail()
-	AddEmailRoutes(emailClient, router)
+	AddEmailRoutes(emailClient, dynamoClient, stores, router)
 
+	router.GET("/.well-known/jwks.json", authentication.JWKSHandler())
 	router.GET("/ws", serveWs)
 	router.POST("/webhook", WebhookHandler)
 	router.GET("/health", func(c *gin.Context) {
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+	"xstudious-guide/amazon"
+	"xstudious-guide/authentication"
+	"xstudious-guide/store"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+// The handler tests run the real routes against the in-memory stores. There
+// is no DynamoDB, S3 or Resend client, so they stick to routes that only use
+// the stores.
+
+const testPassword = "Correct-horse-battery-7"
+
+var (
+	testHashOnce sync.Once
+	testHash     string
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+	authentication.AccessTokenSecret = "test-access-secret"
+	authentication.RefreshTokenSecret = "test-refresh-secret"
+	authentication.ErasureReceiptSecret = "test-receipt-secret"
+}
+
+type testServer struct {
+	t         *testing.T
+	router    *gin.Engine
+	stores    store.Stores
+	presigner *s3.PresignClient
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	stores := store.NewMemoryStores()
+	router := gin.New()
+	// presigning is local, any credentials will do
+	s3client := s3.New(s3.Options{
+		Region: "us-east-1",
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "test", SecretAccessKey: "test"}, nil
+		}),
+	})
+	presigner := s3.NewPresignClient(s3client)
+	AddDynamoDBRoutes(nil, presigner, stores, nil, router)
+	AddS3Routes(s3client, nil, stores, nil, router)
+
+	return &testServer{t: t, router: router, stores: stores, presigner: presigner}
+}
+
+// addUser stores an active, verified user with testPassword.
+func (s *testServer) addUser(id, email string, roles ...string) amazon.User {
+	s.t.Helper()
+
+	testHashOnce.Do(func() {
+		testHash, _ = authentication.HashedPassword(testPassword)
+	})
+	if len(roles) == 0 {
+		roles = []string{authentication.RoleUser}
+	}
+
+	user := amazon.User{
+		ID:          id,
+		Name:        "Test " + id,
+		Email:       email,
+		Password:    testHash,
+		Roles:       roles,
+		EmailStatus: amazon.EmailVerified,
+		Status:      amazon.UserActive,
+		CreatedAt:   time.Now().Unix(),
+	}
+	if err := s.stores.Users.CreateUser(user); err != nil {
+		s.t.Fatalf("creating user %s: %v", id, err)
+	}
+	return user
+}
+
+// do sends a request with an optional JSON body. headers are name, value
+// pairs.
+func (s *testServer) do(method, path string, body interface{}, headers ...string) *httptest.ResponseRecorder {
+	s.t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			s.t.Fatalf("encoding body: %v", err)
+		}
+		reader = bytes.NewReader(raw)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// login signs in with testPassword and returns the access and refresh token.
+func (s *testServer) login(email string) (string, string) {
+	s.t.Helper()
+
+	rec := s.do(http.MethodPost, "/login", gin.H{"email": email, "password": testPassword})
+	if rec.Code != http.StatusOK {
+		s.t.Fatalf("login as %s: %d %s", email, rec.Code, rec.Body)
+	}
+
+	var resp struct {
+		AccessToken  string `json:"accessToken"`
+		RefreshToken string `json:"refreshToken"`
+	}
+	decode(s.t, rec, &resp)
+	return resp.AccessToken, resp.RefreshToken
+}
+
+func bearer(token string) []string {
+	return []string{"Authorization", "Bearer " + token}
+}
+
+func decode(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body, err)
+	}
+}
+
+func expectStatus(t *testing.T, rec *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	if rec.Code != want {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, want, rec.Body)
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"time"
+	"xstudious-guide/amazon"
+	"xstudious-guide/authentication"
+	"xstudious-guide/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetMySessionsReq lists the caller's active sessions, most recently used
+// first. The session the request was made from is marked current.
+func GetMySessionsReq(sessionStore store.SessionStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := authentication.GetClaims(c)
+
+		all, err := sessionStore.GetUserSessions(claims.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get sessions"})
+			return
+		}
+
+		now := time.Now().Unix()
+		sessions := []amazon.Session{}
+		for _, s := range all {
+			if s.Revoked || s.ExpiresAt <= now {
+				continue
+			}
+			s.Current = s.ID == claims.SessionID
+			sessions = append(sessions, s)
+		}
+		sort.Slice(sessions, func(i, j int) bool {
+			return sessions[i].LastSeenAt > sessions[j].LastSeenAt
+		})
+
+		c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+	}
+}
+
+func RevokeMySessionReq(sessions store.SessionStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := authentication.GetClaims(c)
+
+		session, err := sessions.GetSession(c.Param("id"))
+		if err != nil || session.UserID != claims.ID {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+			return
+		}
+
+		if err := sessions.RevokeSession(session.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+	"xstudious-guide/authentication"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/gin-gonic/gin"
+)
+
+const defaultScopedTokenTTL = 5 * time.Minute
+
+// MintScopedTokenReq issues a short-lived token limited to a subset of the
+// caller's scopes, e.g. to hand to a browser widget that only uploads files.
+func MintScopedTokenReq() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Scopes     []string `json:"scopes"`
+			Purpose    string   `json:"purpose"`
+			TTLSeconds int      `json:"ttlSeconds"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || len(req.Scopes) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "At least one scope is required"})
+			return
+		}
+
+		ttl := defaultScopedTokenTTL
+		if req.TTLSeconds > 0 {
+			ttl = time.Duration(req.TTLSeconds) * time.Second
+		}
+		if ttl > authentication.AccessTokenTTL {
+			ttl = authentication.AccessTokenTTL
+		}
+
+		token, err := authentication.NewScopedToken(authentication.GetClaims(c), req.Scopes, ttl, req.Purpose)
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":   "insufficient_scope",
+				"message": err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"accessToken": token,
+			"scopes":      req.Scopes,
+			"expiresIn":   int(ttl.Seconds()),
+		})
+	}
+}
+
+// ClientCredentialsTokenReq implements the OAuth 2.0 client credentials grant
+// (RFC 6749 section 4.4). Client credentials are accepted through HTTP Basic
+// auth or as client_id/client_secret form fields, errors use the RFC codes.
+func ClientCredentialsTokenReq(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.PostForm("grant_type") != "client_credentials" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported_grant_type"})
+			return
+		}
+
+		clientID, clientSecret, ok := c.Request.BasicAuth()
+		if !ok {
+			clientID, clientSecret = c.PostForm("client_id"), c.PostForm("client_secret")
+		}
+		if clientID == "" || clientSecret == "" {
+			c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_client"})
+			return
+		}
+
+		sc, err := authentication.AuthenticateServiceClient(client, clientID, clientSecret)
+		if err != nil {
+			c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_client"})
+			return
+		}
+
+		token, scopes, err := authentication.NewClientAccessToken(sc, strings.Fields(c.PostForm("scope")))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":             "invalid_scope",
+				"error_description": err.Error(),
+			})
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, gin.H{
+			"access_token": token,
+			"token_type":   "Bearer",
+			"expires_in":   int(authentication.AccessTokenTTL.Seconds()),
+			"scope":        strings.Join(scopes, " "),
+		})
+	}
+}
@@ -1,22 +1,25 @@
 package server
 
 import (
-	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 	"xstudious-guide/amazon"
 	"xstudious-guide/authentication"
+	"xstudious-guide/email"
+	"xstudious-guide/store"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt"
 	"github.com/google/uuid"
+	"github.com/resend/resend-go/v2"
 )
 
 func ShortUUID() string {
@@ -28,10 +31,37 @@ func ShortUUID() string {
 	)
 }
 
-func CreateNewUserReq(client *dynamodb.Client) gin.HandlerFunc {
+type RegisterRequest struct {
+	Name        string `form:"name" json:"name"`
+	Email       string `form:"email" json:"email"`
+	Password    string `form:"password" json:"password"`
+	InviteToken string `form:"inviteToken" json:"inviteToken"`
+}
+
+// RegisterPageReq serves the registration form an emailed invite opens. The
+// form POSTs to /register with the invite token.
+func RegisterPageReq(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		invite, err := authentication.PeekOneTimeToken(client, authentication.PurposeInvite, c.Query("invite"))
+		if err != nil {
+			linkErrorPage(c, "Create your account", "This invitation is invalid or has expired. Ask for a new one.")
+			return
+		}
+		linkFormPage(c, "Create your account", "You have been invited to create an account.", "Create account",
+			"inviteToken", c.Query("invite"),
+			linkField{Name: "name", Label: "Name", Type: "text"},
+			linkField{Name: "email", Label: "Email", Type: "email", Value: invite.Email},
+			linkField{Name: "password", Label: "Password", Type: "password"},
+		)
+	}
+}
+
+func CreateNewUserReq(stores store.Stores, client *dynamodb.Client, emailClient *resend.Client) gin.HandlerFunc {
+	users := stores.Users
+
 	return func(c *gin.Context) {
-		var user amazon.User
-		if err := c.ShouldBindJSON(&user); err != nil {
+		var user RegisterRequest
+		if err := c.ShouldBind(&user); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
 			return
 		}
@@ -41,50 +71,78 @@ func CreateNewUserReq(client *dynamodb.Client) gin.HandlerFunc {
 		email := strings.ToLower(user.Email)
 		userId := fmt.Sprintf("u_%s", id)
 
+		if rejectInvalidPassword(c, user.Password, user.Name, email) {
+			return
+		}
+
+		roles := authentication.DefaultRoles(email)
+		emailStatus := amazon.EmailUnverified
+
+		if user.InviteToken != "" || registrationInviteOnly() {
+			if user.InviteToken == "" {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Registration is by invitation only"})
+				return
+			}
+			// only looked up here, the invite is used up once the account exists
+			invite, err := authentication.PeekOneTimeToken(client, authentication.PurposeInvite, user.InviteToken)
+			if err != nil || invite.Email != email {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Invalid or expired invitation for this email"})
+				return
+			}
+
+			if role := invite.Data["role"]; role != "" && !slices.Contains(roles, role) {
+				roles = append(roles, role)
+			}
+			// the invite link went to this address
+			emailStatus = amazon.EmailVerified
+		}
+
 		hashedPassword, err := authentication.HashedPassword(user.Password)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error hashing password"})
 			return
 		}
 
-		newUser := map[string]types.AttributeValue{
-			"id":       &types.AttributeValueMemberS{Value: userId},
-			"name":     &types.AttributeValueMemberS{Value: user.Name},
-			"email":    &types.AttributeValueMemberS{Value: email},
-			"password": &types.AttributeValueMemberS{Value: hashedPassword},
+		created := amazon.User{
+			ID:          userId,
+			Name:        user.Name,
+			Email:       email,
+			Password:    hashedPassword,
+			Roles:       roles,
+			EmailStatus: emailStatus,
+			CreatedAt:   time.Now().Unix(),
 		}
 
-		if err := amazon.CreateUser(client, "users", newUser); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		if err := users.CreateUser(created); err != nil {
+			if errors.Is(err, amazon.ErrEmailTaken) {
+				c.JSON(http.StatusConflict, gin.H{"error": "A user with this email already exists"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 			return
 		}
 
-		claims := authentication.UserClaims{
-			ID:        user.ID,
-			Name:      user.Name,
-			Email:     email,
-			TokenType: "access",
-			StandardClaims: jwt.StandardClaims{
-				ExpiresAt: time.Now().Add(authentication.AccessTokenTTL).Unix(),
-				IssuedAt:  time.Now().Unix(),
-				Subject:   user.ID,
-			},
+		// an invite redeemed in the meantime does not get a second account
+		if user.InviteToken != "" {
+			if _, err := authentication.ConsumeInviteToken(client, user.InviteToken, email); err != nil {
+				if err := users.DeleteUser(userId); err != nil {
+					log.Printf("Failed to remove %s after its invite was refused: %v", userId, err)
+				}
+				c.JSON(http.StatusForbidden, gin.H{"error": "Invalid or expired invitation for this email"})
+				return
+			}
 		}
 
-		accessToken, err := authentication.NewAccessToken(claims)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create access token"})
-			return
+		// the account exists either way, the user can ask for a new link
+		if !created.IsEmailVerified() {
+			if err := sendVerificationEmail(client, users, emailClient, created); err != nil {
+				log.Printf("Failed to send verification email to %s: %v", userId, err)
+			}
 		}
 
-		refreshClaims := jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(authentication.RefreshTokenTTL).Unix(),
-			IssuedAt:  time.Now().Unix(),
-			Subject:   user.ID,
-		}
-		refreshToken, err := authentication.NewRefreshToken(refreshClaims)
+		accessToken, refreshToken, err := authentication.NewTokenPair(stores.Sessions, created, c.Request.UserAgent(), c.ClientIP())
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create refresh token"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create tokens"})
 			return
 		}
 
@@ -97,7 +155,7 @@ func CreateNewUserReq(client *dynamodb.Client) gin.HandlerFunc {
 	}
 }
 
-func AuthUserReq(client *dynamodb.Client) gin.HandlerFunc {
+func AuthUserReq(stores store.Stores, emailClient *resend.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req struct {
 			Email    string `json:"email"`
@@ -113,155 +171,266 @@ func AuthUserReq(client *dynamodb.Client) gin.HandlerFunc {
 			return
 		}
 
-		user, err := amazon.GetUserByEmail(client, "users", req.Email)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "No user found with that email"})
+		if wait := authentication.LoginLockedFor(stores.LoginAttempts, req.Email, c.ClientIP()); wait > 0 {
+			rejectLockedLogin(c, wait)
 			return
 		}
 
-		if user.Password == "" {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "User record missing password"})
+		// Unknown email, passwordless account and wrong password all get the
+		// same answer so the endpoint cannot be used to probe for accounts.
+		user, err := stores.Users.GetUserByEmail(req.Email)
+		if err != nil || user.Password == "" {
+			authentication.CompareDummyPassword(req.Password)
+			authentication.RecordFailedLogin(stores.LoginAttempts, req.Email, c.ClientIP())
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
 			return
 		}
 
 		if !authentication.CheckPasswordHash(req.Password, user.Password) {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Incorrect password"})
+			failLogin(c, stores.LoginAttempts, emailClient, *user)
 			return
 		}
 
-		claims := authentication.UserClaims{
-			ID:        user.ID,
-			Name:      user.Name,
-			Email:     user.Email,
-			TokenType: "access",
-			StandardClaims: jwt.StandardClaims{
-				ExpiresAt: time.Now().Add(authentication.AccessTokenTTL).Unix(),
-				IssuedAt:  time.Now().Unix(),
-				Subject:   user.ID,
-			},
+		if err := authentication.ClearFailedLogins(stores.LoginAttempts, user.Email); err != nil {
+			log.Printf("Failed to clear login attempts for %s: %v", user.ID, err)
 		}
+		rehashPassword(stores.Users, *user, req.Password)
 
-		accessToken, err := authentication.NewAccessToken(claims)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create access token"})
-			return
-		}
+		completeLogin(c, stores.Sessions, *user)
+	}
+}
 
-		refreshClaims := jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(authentication.RefreshTokenTTL).Unix(),
-			IssuedAt:  time.Now().Unix(),
-			Subject:   user.ID,
-		}
+// failLogin counts a failed first or second factor for a known user and
+// emails them when the failure locks the account.
+func failLogin(c *gin.Context, attempts store.LoginAttemptStore, emailClient *resend.Client, user amazon.User) {
+	if authentication.RecordFailedLogin(attempts, user.Email, c.ClientIP()) {
+		go func() {
+			until := time.Now().Add(authentication.LoginLockedFor(attempts, user.Email, ""))
+			if err := email.SendEmail(emailClient, email.LockoutEmail(user.Email, user.Name, until)); err != nil {
+				log.Printf("Failed to send lockout email to %s: %v", user.ID, err)
+			}
+		}()
+	}
 
-		refreshToken, err := authentication.NewRefreshToken(refreshClaims)
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+}
+
+func rejectLockedLogin(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", fmt.Sprintf("%d", int(wait.Seconds())+1))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts, try again later"})
+}
+
+// completeLogin is the last step of every first-factor login. Users with MFA
+// enabled get a challenge token for /login/mfa instead of real tokens.
+func completeLogin(c *gin.Context, sessions store.SessionStore, user amazon.User) {
+	if rejectInactiveLogin(c, user) {
+		return
+	}
+
+	if user.MFAEnabled {
+		mfaToken, err := authentication.NewMFAToken(user.ID)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create refresh token"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create MFA challenge"})
 			return
 		}
 
 		c.JSON(http.StatusOK, gin.H{
-			"message":      "Login successful",
-			"accessToken":  accessToken,
-			"refreshToken": refreshToken,
-			"user":         user,
+			"message":     "MFA required",
+			"mfaRequired": true,
+			"mfaToken":    mfaToken,
 		})
+		return
 	}
+
+	issueLoginTokens(c, sessions, user)
 }
-func GetAllUsersReq(client *dynamodb.Client) gin.HandlerFunc {
+
+func issueLoginTokens(c *gin.Context, sessions store.SessionStore, user amazon.User) {
+	if rejectInactiveLogin(c, user) {
+		return
+	}
+
+	accessToken, refreshToken, err := authentication.NewTokenPair(sessions, user, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Login successful",
+		"accessToken":  accessToken,
+		"refreshToken": refreshToken,
+		"user":         newUserResponse(user, visibilitySelf),
+	})
+}
+
+// GetAllUsersReq is only reachable with users:admin, see AddDynamoDBRoutes.
+func GetAllUsersReq(users store.UserStore, presigner *s3.PresignClient) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
-		if token == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing or invalid token"})
+		query, ok := userQueryParams(c)
+		if !ok {
 			return
 		}
-		if authentication.ParseAccessToken(token) == nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to verify token"})
+
+		page, err := users.ListUsers(query)
+		if errors.Is(err, store.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
 			return
 		}
-
-		resp, err := amazon.GetAllUsers(client, "users")
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get users"})
 			return
 		}
 
-		var users []amazon.User
-		for _, item := range resp {
-			var user amazon.User
-			if err := attributevalue.UnmarshalMap(item, &user); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode users"})
-				return
-			}
-			users = append(users, user)
+		claims := authentication.GetClaims(c)
+
+		resp := make([]UserResponse, 0, len(page.Users))
+		for _, user := range page.Users {
+			resp = append(resp, userResponseWithAvatar(presigner, user, userVisibilityFor(claims, user)))
 		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"message": "Users Found!",
-			"users":   users,
-		})
+		c.JSON(http.StatusOK, newPage(resp, page.NextCursor))
 	}
 }
 
-func GetUserByIDReq(client *dynamodb.Client) gin.HandlerFunc {
+// userQueryParams reads the filters of GET /users: name (prefix), domain,
+// role, createdAfter (RFC 3339 or unix seconds) and sort, a field name with
+// an optional "-" for descending order.
+func userQueryParams(c *gin.Context) (store.UserQuery, bool) {
+	limit, cursor, ok := pageParams(c)
+	if !ok {
+		return store.UserQuery{}, false
+	}
+
+	query := store.UserQuery{
+		Filter: amazon.UserFilter{
+			NamePrefix:  c.Query("name"),
+			EmailDomain: strings.TrimPrefix(c.Query("domain"), "@"),
+			Role:        c.Query("role"),
+		},
+		Limit:  limit,
+		Cursor: cursor,
+	}
+
+	if raw := c.Query("createdAfter"); raw != "" {
+		if t, err := time.Parse(time.RFC3339, raw); err == nil {
+			query.Filter.CreatedAfter = t.Unix()
+		} else if n, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			query.Filter.CreatedAfter = n
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "createdAfter must be an RFC 3339 time or unix seconds"})
+			return store.UserQuery{}, false
+		}
+	}
+
+	if sortBy := c.Query("sort"); sortBy != "" {
+		query.Desc = strings.HasPrefix(sortBy, "-")
+		query.Sort = strings.TrimPrefix(sortBy, "-")
+		if !store.IsUserSort(query.Sort) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be name, email or createdAt, prefixed with - for descending"})
+			return store.UserQuery{}, false
+		}
+	}
+
+	return query, true
+}
+
+func GetUserByIDReq(users store.UserStore, presigner *s3.PresignClient) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
+		claims := authentication.GetClaims(c)
 
-		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
-		if token == "" || authentication.ParseAccessToken(token) == nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to verify token"})
+		if !authentication.CanActOnUser(claims, id, authentication.PermUsersAdmin) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed to view this user"})
 			return
 		}
 
-		resp, err := amazon.GetUserById(client, "users", id)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		user, err := users.GetUser(id)
+		if errors.Is(err, amazon.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 			return
 		}
-
-		var user amazon.User
-		if err := attributevalue.UnmarshalMap(resp, &user); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode user"})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
 			return
 		}
 
+		c.Header("ETag", userETag(user.Version))
 		c.JSON(http.StatusOK, gin.H{
 			"message": "User Found!",
-			"user":    user,
+			"user":    userResponseWithAvatar(presigner, *user, userVisibilityFor(claims, *user)),
 		})
 	}
 }
 
-func UpdateUserReq(client *dynamodb.Client) gin.HandlerFunc {
+func UpdateUserReq(users store.UserStore, client *dynamodb.Client, emailClient *resend.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
-		if token == "" || authentication.ParseAccessToken(token) == nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to verify token"})
+		var req struct {
+			ID    string `json:"id"`
+			Name  string `json:"name"`
+			Email string `json:"email"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
 			return
 		}
 
-		var user amazon.User
-		if err := c.ShouldBindJSON(&user); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		claims := authentication.GetClaims(c)
+		if req.ID == "" {
+			req.ID = claims.ID
+		}
+		if !authentication.CanActOnUser(claims, req.ID, authentication.PermUsersAdmin) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed to update this user"})
 			return
 		}
 
-		if err := amazon.UpdateUser(client, "users", user); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		current, ok := findAccount(c, users, req.ID)
+		if !ok {
 			return
 		}
+		if !ifMatch(c, current.Version) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": "User has changed, fetch it again"})
+			return
+		}
+
+		user := amazon.User{
+			ID:    req.ID,
+			Name:  req.Name,
+			Email: strings.ToLower(req.Email),
+		}
+
+		if err := users.UpdateUser(user, current.Version); err != nil {
+			switch {
+			case errors.Is(err, amazon.ErrUserNotFound):
+				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			case errors.Is(err, amazon.ErrVersionConflict):
+				c.JSON(http.StatusPreconditionFailed, gin.H{"error": "User has changed, fetch it again"})
+			case errors.Is(err, amazon.ErrEmailTaken):
+				c.JSON(http.StatusConflict, gin.H{"error": "Email is already in use"})
+			case errors.Is(err, amazon.ErrNothingToUpdate):
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to update"})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+			}
+			return
+		}
+
+		if user.Email != "" {
+			if updated, err := users.GetUser(user.ID); err == nil && !updated.IsEmailVerified() {
+				if err := sendVerificationEmail(client, users, emailClient, *updated); err != nil {
+					log.Printf("Failed to send verification email to %s: %v", updated.ID, err)
+				}
+			}
+		}
 
+		c.Header("ETag", userETag(current.Version+1))
 		c.JSON(http.StatusOK, gin.H{"message": "User Updated!"})
 	}
 }
 
-func UpdatePasswordReq(client *dynamodb.Client) gin.HandlerFunc {
+func UpdatePasswordReq(users store.UserStore) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
-		claims := authentication.ParseAccessToken(token)
-		if token == "" || claims == nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to verify token"})
-			return
-		}
+		claims := authentication.GetClaims(c)
 
 		var req struct {
 			CurrentPassword string `json:"currentPassword"`
@@ -278,26 +447,13 @@ func UpdatePasswordReq(client *dynamodb.Client) gin.HandlerFunc {
 			return
 		}
 
-		out, err := client.GetItem(context.TODO(), &dynamodb.GetItemInput{
-			TableName: aws.String("users"),
-			Key: map[string]types.AttributeValue{
-				"id": &types.AttributeValueMemberS{Value: claims.ID},
-			},
-		})
-
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
-			return
-		}
-
-		if out.Item == nil {
+		user, err := users.GetUser(claims.ID)
+		if errors.Is(err, amazon.ErrUserNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 			return
 		}
-
-		var user amazon.User
-		if err := attributevalue.UnmarshalMap(out.Item, &user); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse user record"})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
 			return
 		}
 
@@ -306,14 +462,17 @@ func UpdatePasswordReq(client *dynamodb.Client) gin.HandlerFunc {
 			return
 		}
 
+		if rejectInvalidPassword(c, req.NewPassword, user.Name, user.Email) {
+			return
+		}
+
 		hashedPassword, err := authentication.HashedPassword(req.NewPassword)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash new password"})
 			return
 		}
 
-		user.Password = hashedPassword
-		if err := amazon.UpdatePassword(client, "users", user); err != nil {
+		if err := users.UpdatePassword(user.ID, hashedPassword); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
 			return
 		}
@@ -322,21 +481,87 @@ func UpdatePasswordReq(client *dynamodb.Client) gin.HandlerFunc {
 	}
 }
 
-func DeleteUserReq(client *dynamodb.Client) gin.HandlerFunc {
+// DeleteUserReq soft-deletes an account. It stops working at once and is
+// purged with its files and sessions once the grace period has passed, see
+// StartPurgeJob. Until then an admin can restore it.
+func DeleteUserReq(stores store.Stores, client *dynamodb.Client) gin.HandlerFunc {
+	users := stores.Users
+
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		claims := authentication.GetClaims(c)
+
+		if !authentication.CanActOnUser(claims, id, authentication.PermUsersAdmin) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed to delete this user"})
+			return
+		}
+
+		user, ok := findAccount(c, users, id)
+		if !ok {
+			return
+		}
+		if user.Status == amazon.UserDeleted {
+			c.JSON(http.StatusConflict, gin.H{"error": "User is already scheduled for deletion"})
+			return
+		}
+
+		purgeAt := time.Now().Add(deletionGracePeriod()).Unix()
+		if !setAccountStatus(c, stores, client, user, amazon.UserDeleted, purgeAt, authentication.AuditUserDelete) {
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "User Deleted!",
+			"purgeAt": purgeAt,
+		})
+	}
+}
+
+func UpdateUserRolesReq(users store.UserStore) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
 
-		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
-		if token == "" || authentication.ParseAccessToken(token) == nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to verify token"})
+		var req struct {
+			Roles []string `json:"roles"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || len(req.Roles) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		for _, role := range req.Roles {
+			if !authentication.IsKnownRole(role) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown role %s", role)})
+				return
+			}
+		}
+
+		if err := users.UpdateRoles(id, req.Roles); err != nil {
+			if errors.Is(err, amazon.ErrUserNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update roles"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Roles Updated!"})
+	}
+}
+
+func UnlockUserReq(users store.UserStore, attempts store.LoginAttemptStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := users.GetUser(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 			return
 		}
 
-		if err := amazon.DeleteUser(client, "users", id); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		if err := authentication.ClearFailedLogins(attempts, user.Email); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock user"})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "User Deleted!"})
+		c.JSON(http.StatusOK, gin.H{"message": "User Unlocked!"})
 	}
 }
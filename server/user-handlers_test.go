@@ -0,0 +1,304 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"xstudious-guide/amazon"
+	"xstudious-guide/authentication"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLoginTokensReachProtectedRoutes(t *testing.T) {
+	s := newTestServer(t)
+	s.addUser("u_alice", "alice@example.com")
+
+	access, _ := s.login("alice@example.com")
+
+	rec := s.do(http.MethodGet, "/users/u_alice", nil, bearer(access)...)
+	expectStatus(t, rec, http.StatusOK)
+	if etag := rec.Header().Get("ETag"); etag != `"1"` {
+		t.Errorf("ETag = %s, want \"1\"", etag)
+	}
+
+	rec = s.do(http.MethodGet, "/users/u_alice", nil)
+	expectStatus(t, rec, http.StatusUnauthorized)
+}
+
+func TestLoginRejectsWrongPasswordAndLocksAccount(t *testing.T) {
+	s := newTestServer(t)
+	s.addUser("u_alice", "alice@example.com")
+
+	rec := s.do(http.MethodPost, "/login", gin.H{"email": "nobody@example.com", "password": testPassword})
+	expectStatus(t, rec, http.StatusUnauthorized)
+
+	for i := 0; i < 5; i++ {
+		rec = s.do(http.MethodPost, "/login", gin.H{"email": "alice@example.com", "password": "wrong-password-1"})
+		expectStatus(t, rec, http.StatusUnauthorized)
+	}
+
+	// the right password does not help while the account is locked
+	rec = s.do(http.MethodPost, "/login", gin.H{"email": "alice@example.com", "password": testPassword})
+	expectStatus(t, rec, http.StatusTooManyRequests)
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("missing Retry-After header")
+	}
+}
+
+func TestLoginRejectsInactiveAccount(t *testing.T) {
+	s := newTestServer(t)
+	s.addUser("u_alice", "alice@example.com")
+	if err := s.stores.Users.SetStatus("u_alice", amazon.UserDeactivated, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := s.do(http.MethodPost, "/login", gin.H{"email": "alice@example.com", "password": testPassword})
+	expectStatus(t, rec, http.StatusForbidden)
+}
+
+func TestSessionlessTokensStopWorkingWhenAccountIsInactive(t *testing.T) {
+	s := newTestServer(t)
+	user := s.addUser("u_alice", "alice@example.com")
+
+	// scoped and impersonation tokens can be minted without a session
+	token, err := authentication.NewAccessToken(authentication.NewUserClaims(user))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, s.do(http.MethodGet, "/users/u_alice", nil, bearer(token)...), http.StatusOK)
+
+	if err := s.stores.Users.SetStatus("u_alice", amazon.UserDeactivated, 0); err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, s.do(http.MethodGet, "/users/u_alice", nil, bearer(token)...), http.StatusUnauthorized)
+}
+
+func TestRefreshTokenRotationDetectsReuse(t *testing.T) {
+	s := newTestServer(t)
+	s.addUser("u_alice", "alice@example.com")
+	_, refresh := s.login("alice@example.com")
+
+	rec := s.do(http.MethodPost, "/refresh-token", gin.H{"refreshToken": refresh})
+	expectStatus(t, rec, http.StatusOK)
+	var rotated struct {
+		AccessToken  string `json:"accessToken"`
+		RefreshToken string `json:"refreshToken"`
+	}
+	decode(t, rec, &rotated)
+
+	// replaying the first refresh token revokes the whole session
+	rec = s.do(http.MethodPost, "/refresh-token", gin.H{"refreshToken": refresh})
+	expectStatus(t, rec, http.StatusUnauthorized)
+
+	rec = s.do(http.MethodPost, "/refresh-token", gin.H{"refreshToken": rotated.RefreshToken})
+	expectStatus(t, rec, http.StatusUnauthorized)
+	rec = s.do(http.MethodGet, "/users/u_alice", nil, bearer(rotated.AccessToken)...)
+	expectStatus(t, rec, http.StatusUnauthorized)
+}
+
+func TestLogoutRevokesAccessTokens(t *testing.T) {
+	s := newTestServer(t)
+	s.addUser("u_alice", "alice@example.com")
+	first, _ := s.login("alice@example.com")
+	second, refresh := s.login("alice@example.com")
+
+	rec := s.do(http.MethodPost, "/logout", gin.H{"refreshToken": refresh})
+	expectStatus(t, rec, http.StatusOK)
+	expectStatus(t, s.do(http.MethodGet, "/users/u_alice", nil, bearer(second)...), http.StatusUnauthorized)
+	expectStatus(t, s.do(http.MethodGet, "/users/u_alice", nil, bearer(first)...), http.StatusOK)
+
+	rec = s.do(http.MethodPost, "/logout-all", nil, bearer(first)...)
+	expectStatus(t, rec, http.StatusOK)
+	expectStatus(t, s.do(http.MethodGet, "/users/u_alice", nil, bearer(first)...), http.StatusUnauthorized)
+}
+
+func TestUpdateUserChecksIfMatch(t *testing.T) {
+	s := newTestServer(t)
+	s.addUser("u_alice", "alice@example.com")
+	access, _ := s.login("alice@example.com")
+
+	auth := bearer(access)
+	rec := s.do(http.MethodPut, "/users", gin.H{"name": "Alice"}, append(auth, "If-Match", `"1"`)...)
+	expectStatus(t, rec, http.StatusOK)
+	if etag := rec.Header().Get("ETag"); etag != `"2"` {
+		t.Errorf("ETag = %s, want \"2\"", etag)
+	}
+
+	rec = s.do(http.MethodPut, "/users", gin.H{"name": "Alicia"}, append(auth, "If-Match", `"1"`)...)
+	expectStatus(t, rec, http.StatusPreconditionFailed)
+
+	user, err := s.stores.Users.GetUser("u_alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "Alice" {
+		t.Errorf("name = %q, want Alice", user.Name)
+	}
+}
+
+func TestUpdateMeChecksIfMatch(t *testing.T) {
+	s := newTestServer(t)
+	s.addUser("u_alice", "alice@example.com")
+	access, _ := s.login("alice@example.com")
+
+	auth := bearer(access)
+	patch := gin.H{"preferences": gin.H{"theme": "dark"}}
+	rec := s.do(http.MethodPatch, "/me", patch, append(auth, "If-Match", `"1"`)...)
+	expectStatus(t, rec, http.StatusOK)
+	if etag := rec.Header().Get("ETag"); etag != `"2"` {
+		t.Errorf("ETag = %s, want \"2\"", etag)
+	}
+
+	patch = gin.H{"preferences": gin.H{"language": "de"}}
+	rec = s.do(http.MethodPatch, "/me", patch, append(auth, "If-Match", `"1"`)...)
+	expectStatus(t, rec, http.StatusPreconditionFailed)
+
+	// a write racing another one is refused by the store, not merged over it
+	err := s.stores.Users.UpdateProfile("u_alice", amazon.ProfileUpdate{Preferences: map[string]interface{}{"language": "de"}}, 1)
+	if !errors.Is(err, amazon.ErrVersionConflict) {
+		t.Errorf("stale profile write: err = %v, want ErrVersionConflict", err)
+	}
+
+	user, err := s.stores.Users.GetUser("u_alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Preferences["theme"] != "dark" || user.Preferences["language"] != nil {
+		t.Errorf("preferences = %v, want only theme", user.Preferences)
+	}
+}
+
+func TestTokensWithoutScopesHoldNone(t *testing.T) {
+	s := newTestServer(t)
+	user := s.addUser("u_alice", "alice@example.com")
+
+	claims := authentication.NewUserClaims(user)
+	claims.Scopes = nil
+	token, err := authentication.NewAccessToken(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, s.do(http.MethodGet, "/me", nil, bearer(token)...), http.StatusForbidden)
+	expectStatus(t, s.do(http.MethodGet, "/users/u_alice", nil, bearer(token)...), http.StatusForbidden)
+}
+
+func TestUsersCannotReadOtherUsers(t *testing.T) {
+	s := newTestServer(t)
+	s.addUser("u_alice", "alice@example.com")
+	s.addUser("u_bob", "bob@example.com")
+	access, _ := s.login("alice@example.com")
+
+	expectStatus(t, s.do(http.MethodGet, "/users/u_bob", nil, bearer(access)...), http.StatusForbidden)
+	expectStatus(t, s.do(http.MethodGet, "/users", nil, bearer(access)...), http.StatusForbidden)
+}
+
+func TestGetAllUsersPaginates(t *testing.T) {
+	s := newTestServer(t)
+	s.addUser("u_admin", "admin@example.com", "user", "admin")
+	s.addUser("u_alice", "alice@example.com")
+	s.addUser("u_bob", "bob@example.com")
+	access, _ := s.login("admin@example.com")
+
+	var seen []string
+	path := "/users?limit=2"
+	for {
+		rec := s.do(http.MethodGet, path, nil, bearer(access)...)
+		expectStatus(t, rec, http.StatusOK)
+
+		var page struct {
+			Items []struct {
+				ID string `json:"id"`
+			} `json:"items"`
+			NextCursor string `json:"nextCursor"`
+		}
+		decode(t, rec, &page)
+		for _, u := range page.Items {
+			seen = append(seen, u.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		path = "/users?limit=2&cursor=" + page.NextCursor
+	}
+
+	if len(seen) != 3 || seen[0] != "u_admin" || seen[1] != "u_alice" || seen[2] != "u_bob" {
+		t.Errorf("listed %v, want all three users in ID order", seen)
+	}
+}
+
+func TestResponsesNeverContainSecrets(t *testing.T) {
+	s := newTestServer(t)
+	s.addUser("u_admin", "admin@example.com", "user", "admin")
+	s.addUser("u_alice", "alice@example.com")
+
+	// alice has an enrollment in progress, bob has MFA enabled
+	aliceSecret := "JBSWY3DPEHPK3PXPALICE"
+	if err := s.stores.Users.SetPendingTOTPSecret("u_alice", aliceSecret); err != nil {
+		t.Fatal(err)
+	}
+	bob := s.addUser("u_bob", "bob@example.com")
+	bobSecret := "JBSWY3DPEHPK3PXPBOB"
+	bobCodes := []string{authentication.HashToken("recovery-1"), authentication.HashToken("recovery-2")}
+	if err := s.stores.Users.SetPendingTOTPSecret("u_bob", bobSecret); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.stores.Users.EnableMFA("u_bob", bobCodes, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("AWS_BUCKET", "test-bucket")
+	stored, err := s.stores.Users.GetUser("u_bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.stores.Users.SetAvatar("u_bob", "u_bob/avatar-1.png", stored.Version); err != nil {
+		t.Fatal(err)
+	}
+
+	secrets := append([]string{bob.Password, aliceSecret, bobSecret, `"password"`, `"totpSecret"`, `"recoveryCodes"`, `"avatarKey"`}, bobCodes...)
+	check := func(what string, rec *httptest.ResponseRecorder) {
+		t.Helper()
+		expectStatus(t, rec, http.StatusOK)
+		for _, secret := range secrets {
+			if strings.Contains(rec.Body.String(), secret) {
+				t.Errorf("%s leaks %s: %s", what, secret, rec.Body)
+			}
+		}
+	}
+
+	check("/login", s.do(http.MethodPost, "/login", gin.H{"email": "alice@example.com", "password": testPassword}))
+	alice, _ := s.login("alice@example.com")
+	check("/me", s.do(http.MethodGet, "/me", nil, bearer(alice)...))
+	check("/users/:id", s.do(http.MethodGet, "/users/u_alice", nil, bearer(alice)...))
+
+	admin, _ := s.login("admin@example.com")
+	check("/users", s.do(http.MethodGet, "/users", nil, bearer(admin)...))
+	rec := s.do(http.MethodGet, "/users/u_bob", nil, bearer(admin)...)
+	check("/users/:id as admin", rec)
+	if !strings.Contains(rec.Body.String(), `"avatarUrl"`) {
+		t.Errorf("/users/:id as admin has no avatarUrl: %s", rec.Body)
+	}
+	check("/users/:id as admin", s.do(http.MethodGet, "/users/u_alice", nil, bearer(admin)...))
+
+	// no route shows a stranger's profile yet, check the public view itself
+	stored, err = s.stores.Users.GetUser("u_bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := json.Marshal(userResponseWithAvatar(s.presigner, *stored, visibilityPublic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(public), `"avatarUrl"`) {
+		t.Errorf("public view has no avatarUrl: %s", public)
+	}
+	for _, private := range append(secrets, `"email"`, `"roles"`, `"preferences"`) {
+		if strings.Contains(string(public), private) {
+			t.Errorf("public view leaks %s: %s", private, public)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"xstudious-guide/amazon"
+	"xstudious-guide/authentication"
+)
+
+// amazon.User is the storage model and is never written to a response. User
+// handlers return a UserResponse filled according to who is asking. AvatarURL
+// is public like the name, and is set by userResponseWithAvatar.
+type userVisibility int
+
+const (
+	visibilityPublic userVisibility = iota // other users, e.g. fellow org members
+	visibilityAdmin                        // callers holding users:admin
+	visibilitySelf                         // the user themselves
+)
+
+type UserResponse struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName,omitempty"`
+	Bio         string `json:"bio,omitempty"`
+	AvatarURL   string `json:"avatarUrl,omitempty"`
+
+	// self and admin only
+	Email         string   `json:"email,omitempty"`
+	EmailVerified *bool    `json:"emailVerified,omitempty"`
+	Roles         []string `json:"roles,omitempty"`
+	MFAEnabled    *bool    `json:"mfaEnabled,omitempty"`
+	Timezone      string   `json:"timezone,omitempty"`
+	Locale        string   `json:"locale,omitempty"`
+	Status        string   `json:"status,omitempty"`
+	PurgeAt       int64    `json:"purgeAt,omitempty"`
+
+	// self only
+	Preferences map[string]interface{} `json:"preferences,omitempty"`
+}
+
+func newUserResponse(user amazon.User, visibility userVisibility) UserResponse {
+	resp := UserResponse{
+		ID:          user.ID,
+		Name:        user.Name,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+	}
+	if visibility == visibilityPublic {
+		return resp
+	}
+
+	verified := user.IsEmailVerified()
+	mfa := user.MFAEnabled
+	resp.Email = user.Email
+	resp.EmailVerified = &verified
+	resp.Roles = user.Roles
+	resp.MFAEnabled = &mfa
+	resp.Timezone = user.Timezone
+	resp.Locale = user.Locale
+	resp.Status = user.Status
+	resp.PurgeAt = user.PurgeAt
+
+	if visibility == visibilitySelf {
+		resp.Preferences = user.Preferences
+	}
+	return resp
+}
+
+// userVisibilityFor decides how much of user the caller may see.
+func userVisibilityFor(claims *authentication.UserClaims, user amazon.User) userVisibility {
+	switch {
+	case claims != nil && claims.ID == user.ID:
+		return visibilitySelf
+	case authentication.HasPermission(claims, authentication.PermUsersAdmin):
+		return visibilityAdmin
+	default:
+		return visibilityPublic
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+	"xstudious-guide/amazon"
+	"xstudious-guide/authentication"
+	"xstudious-guide/email"
+	"xstudious-guide/store"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/gin-gonic/gin"
+	"github.com/resend/resend-go/v2"
+)
+
+const (
+	verificationTTL      = 24 * time.Hour
+	verificationCooldown = time.Minute
+)
+
+// sendVerificationEmail mails a fresh single-use verification link. It returns
+// amazon.ErrThrottled if a link was sent to this user less than a minute ago.
+func sendVerificationEmail(client *dynamodb.Client, users store.UserStore, emailClient *resend.Client, user amazon.User) error {
+	now := time.Now()
+	if err := users.ClaimVerificationSend(user.ID, now.Unix(), now.Add(-verificationCooldown).Unix()); err != nil {
+		return err
+	}
+
+	token, err := authentication.NewOneTimeToken(client, authentication.PurposeVerifyEmail, user.ID, user.Email, verificationTTL)
+	if err != nil {
+		return fmt.Errorf("failed to create verification token: %w", err)
+	}
+
+	link := email.AppURL("/verify-email?token=" + url.QueryEscape(token))
+	return email.SendEmail(emailClient, email.VerificationEmail(user.Email, user.Name, link))
+}
+
+// VerifyEmailPageReq serves the page the emailed link opens. Verifying takes
+// a click, which POSTs the token to VerifyEmailReq.
+func VerifyEmailPageReq(client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, err := authentication.PeekOneTimeToken(client, authentication.PurposeVerifyEmail, c.Query("token")); err != nil {
+			linkErrorPage(c, "Verify your email address", "This verification link is invalid or has expired. You can ask for a new one.")
+			return
+		}
+		linkConfirmPage(c, "Verify your email address", "Confirm that this email address belongs to you.", "Verify email")
+	}
+}
+
+func VerifyEmailReq(users store.UserStore, client *dynamodb.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, err := authentication.ConsumeOneTimeToken(client, authentication.PurposeVerifyEmail, linkToken(c))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification link"})
+			return
+		}
+
+		user, err := users.GetUser(token.UserID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification link"})
+			return
+		}
+
+		// the address changed after this link was sent
+		if user.Email != token.Email {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification link"})
+			return
+		}
+
+		if err := users.SetEmailVerified(user.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Email verified! Refresh your token to pick up the change."})
+	}
+}
+
+func ResendVerificationReq(users store.UserStore, client *dynamodb.Client, emailClient *resend.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := authentication.GetClaims(c)
+
+		user, err := users.GetUser(claims.ID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		if user.IsEmailVerified() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Email is already verified"})
+			return
+		}
+
+		err = sendVerificationEmail(client, users, emailClient, *user)
+		if errors.Is(err, amazon.ErrThrottled) {
+			c.Header("Retry-After", fmt.Sprintf("%d", int(verificationCooldown.Seconds())))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Verification email was sent recently, try again later"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+	}
+}
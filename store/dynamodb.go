@@ -0,0 +1,272 @@
+package store
+
+import (
+	"xstudious-guide/amazon"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+type dynamoUserStore struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+func NewDynamoUserStore(client *dynamodb.Client, tableName string) UserStore {
+	return &dynamoUserStore{client: client, tableName: tableName}
+}
+
+func (s *dynamoUserStore) CreateUser(user amazon.User) error {
+	item, err := attributevalue.MarshalMap(user)
+	if err != nil {
+		return err
+	}
+	return amazon.CreateUser(s.client, s.tableName, item)
+}
+
+func (s *dynamoUserStore) GetUser(id string) (*amazon.User, error) {
+	return amazon.FindUserById(s.client, s.tableName, id)
+}
+
+func (s *dynamoUserStore) GetUserByEmail(email string) (*amazon.User, error) {
+	return amazon.GetUserByEmail(s.client, s.tableName, email)
+}
+
+func (s *dynamoUserStore) ListUsers(query UserQuery) (UserPage, error) {
+	if query.Sort != "" {
+		return s.listSorted(query)
+	}
+
+	after, err := decodeCursor(query.Cursor, query)
+	if err != nil {
+		return UserPage{}, err
+	}
+
+	users, nextID, err := amazon.ScanUsers(s.client, s.tableName, query.Filter, query.Limit, after.ID)
+	if err != nil {
+		return UserPage{}, err
+	}
+
+	page := UserPage{Users: users}
+	if nextID != "" {
+		page.NextCursor = cursor{ID: nextID}.encode()
+	}
+	return page, nil
+}
+
+// listSorted scans the whole table, DynamoDB cannot order a scan.
+func (s *dynamoUserStore) listSorted(query UserQuery) (UserPage, error) {
+	items, err := amazon.GetAllUsers(s.client, s.tableName)
+	if err != nil {
+		return UserPage{}, err
+	}
+
+	var all []amazon.User
+	if err := attributevalue.UnmarshalListOfMaps(items, &all); err != nil {
+		return UserPage{}, err
+	}
+
+	var users []amazon.User
+	for _, u := range all {
+		if query.Filter.Matches(u) {
+			users = append(users, u)
+		}
+	}
+	return sortedPage(users, query)
+}
+
+func (s *dynamoUserStore) UpdateUser(user amazon.User, version int64) error {
+	return amazon.UpdateUser(s.client, s.tableName, user, version)
+}
+
+func (s *dynamoUserStore) UpdatePassword(id, hash string) error {
+	return amazon.UpdatePassword(s.client, s.tableName, amazon.User{ID: id, Password: hash})
+}
+
+func (s *dynamoUserStore) UpdateRoles(id string, roles []string) error {
+	return amazon.UpdateUserRoles(s.client, s.tableName, id, roles)
+}
+
+func (s *dynamoUserStore) UpdateProfile(id string, profile amazon.ProfileUpdate, version int64) error {
+	return amazon.UpdateUserProfile(s.client, s.tableName, id, profile, version)
+}
+
+func (s *dynamoUserStore) SetAvatar(id, key string, version int64) error {
+	return amazon.SetUserAvatar(s.client, s.tableName, id, key, version)
+}
+
+func (s *dynamoUserStore) SetStatus(id, status string, purgeAt int64) error {
+	return amazon.SetUserStatus(s.client, s.tableName, id, status, purgeAt)
+}
+
+func (s *dynamoUserStore) DeleteUser(id string) error {
+	return amazon.DeleteUser(s.client, s.tableName, id)
+}
+
+func (s *dynamoUserStore) UsersDueForPurge(now int64) ([]amazon.User, error) {
+	return amazon.GetUsersDueForPurge(s.client, s.tableName, now)
+}
+
+func (s *dynamoUserStore) SetEmailVerified(id string) error {
+	return amazon.SetEmailVerified(s.client, s.tableName, id)
+}
+
+func (s *dynamoUserStore) ClaimVerificationSend(id string, sentAt, notBefore int64) error {
+	return amazon.ClaimVerificationSend(s.client, s.tableName, id, sentAt, notBefore)
+}
+
+func (s *dynamoUserStore) ClaimMagicLinkSend(id string, sentAt, notBefore int64) error {
+	return amazon.ClaimMagicLinkSend(s.client, s.tableName, id, sentAt, notBefore)
+}
+
+func (s *dynamoUserStore) ClaimResetSend(id string, sentAt, notBefore int64) error {
+	return amazon.ClaimResetSend(s.client, s.tableName, id, sentAt, notBefore)
+}
+
+func (s *dynamoUserStore) ClaimExportRequest(id string, requestedAt, notBefore int64) error {
+	return amazon.ClaimExportRequest(s.client, s.tableName, id, requestedAt, notBefore)
+}
+
+func (s *dynamoUserStore) SetPendingTOTPSecret(id, secret string) error {
+	return amazon.SetPendingTOTPSecret(s.client, s.tableName, id, secret)
+}
+
+func (s *dynamoUserStore) EnableMFA(id string, recoveryCodes []string, step int64) error {
+	return amazon.EnableMFA(s.client, s.tableName, id, recoveryCodes, step)
+}
+
+func (s *dynamoUserStore) DisableMFA(id string) error {
+	return amazon.DisableMFA(s.client, s.tableName, id)
+}
+
+func (s *dynamoUserStore) RecordTOTPStep(id string, step int64) error {
+	return amazon.RecordTOTPStep(s.client, s.tableName, id, step)
+}
+
+func (s *dynamoUserStore) UseRecoveryCode(id string, index int, hash string) error {
+	return amazon.UseRecoveryCode(s.client, s.tableName, id, index, hash)
+}
+
+type dynamoFileStore struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+func NewDynamoFileStore(client *dynamodb.Client, tableName string) FileStore {
+	return &dynamoFileStore{client: client, tableName: tableName}
+}
+
+func (s *dynamoFileStore) SaveFile(file amazon.UserFile) error {
+	return amazon.SaveUserFile(s.client, s.tableName, file)
+}
+
+func (s *dynamoFileStore) GetUserFiles(userID string) ([]amazon.UserFile, error) {
+	return amazon.GetUserFiles(s.client, s.tableName, userID)
+}
+
+func (s *dynamoFileStore) GetOrgFiles(orgID string) ([]amazon.UserFile, error) {
+	return amazon.GetOrgFiles(s.client, s.tableName, orgID)
+}
+
+func (s *dynamoFileStore) DeleteFile(userID, fileID string) error {
+	return amazon.DeleteUserFile(s.client, s.tableName, userID, fileID)
+}
+
+type dynamoSessionStore struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+func NewDynamoSessionStore(client *dynamodb.Client, tableName string) SessionStore {
+	return &dynamoSessionStore{client: client, tableName: tableName}
+}
+
+func (s *dynamoSessionStore) CreateSession(session amazon.Session) error {
+	return amazon.CreateSession(s.client, s.tableName, session)
+}
+
+func (s *dynamoSessionStore) GetSession(id string) (*amazon.Session, error) {
+	return amazon.GetSessionById(s.client, s.tableName, id)
+}
+
+func (s *dynamoSessionStore) GetUserSessions(userID string) ([]amazon.Session, error) {
+	return amazon.GetUserSessions(s.client, s.tableName, userID)
+}
+
+func (s *dynamoSessionStore) RotateSession(oldTokenID string, next amazon.Session) error {
+	return amazon.RotateSession(s.client, s.tableName, oldTokenID, next)
+}
+
+func (s *dynamoSessionStore) RevokeSession(id string) error {
+	return amazon.RevokeSession(s.client, s.tableName, id)
+}
+
+func (s *dynamoSessionStore) RevokeUserSessions(userID string) error {
+	return amazon.RevokeUserSessions(s.client, s.tableName, userID)
+}
+
+func (s *dynamoSessionStore) DeleteUserSessions(userID string) (int, error) {
+	return amazon.DeleteUserSessions(s.client, s.tableName, userID)
+}
+
+func (s *dynamoSessionStore) SetSessionOrg(id, orgID string) error {
+	return amazon.SetSessionOrg(s.client, s.tableName, id, orgID)
+}
+
+type dynamoLoginAttemptStore struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+func NewDynamoLoginAttemptStore(client *dynamodb.Client, tableName string) LoginAttemptStore {
+	return &dynamoLoginAttemptStore{client: client, tableName: tableName}
+}
+
+func (s *dynamoLoginAttemptStore) GetLoginAttempts(id string) (*amazon.LoginAttempts, error) {
+	return amazon.GetLoginAttempts(s.client, s.tableName, id)
+}
+
+func (s *dynamoLoginAttemptStore) RecordLoginFailure(id string, now, windowStart int64) (*amazon.LoginAttempts, error) {
+	return amazon.RecordLoginFailure(s.client, s.tableName, id, now, windowStart)
+}
+
+func (s *dynamoLoginAttemptStore) SetLockout(id string, until int64) error {
+	return amazon.SetLockout(s.client, s.tableName, id, until)
+}
+
+func (s *dynamoLoginAttemptStore) ClearLoginAttempts(id string) error {
+	return amazon.ClearLoginAttempts(s.client, s.tableName, id)
+}
+
+type dynamoAPIKeyStore struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+func NewDynamoAPIKeyStore(client *dynamodb.Client, tableName string) APIKeyStore {
+	return &dynamoAPIKeyStore{client: client, tableName: tableName}
+}
+
+func (s *dynamoAPIKeyStore) CreateAPIKey(key amazon.APIKey) error {
+	return amazon.CreateAPIKey(s.client, s.tableName, key)
+}
+
+func (s *dynamoAPIKeyStore) GetAPIKey(id string) (*amazon.APIKey, error) {
+	return amazon.GetAPIKey(s.client, s.tableName, id)
+}
+
+func (s *dynamoAPIKeyStore) GetUserAPIKeys(userID string) ([]amazon.APIKey, error) {
+	return amazon.GetUserAPIKeys(s.client, s.tableName, userID)
+}
+
+func (s *dynamoAPIKeyStore) RevokeAPIKey(id, userID string) error {
+	return amazon.RevokeAPIKey(s.client, s.tableName, id, userID)
+}
+
+func (s *dynamoAPIKeyStore) DeleteAPIKey(id string) error {
+	return amazon.DeleteAPIKey(s.client, s.tableName, id)
+}
+
+func (s *dynamoAPIKeyStore) TouchAPIKey(id string, usedAt int64) error {
+	return amazon.TouchAPIKey(s.client, s.tableName, id, usedAt)
+}
@@ -0,0 +1,692 @@
+package store
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"xstudious-guide/amazon"
+)
+
+// MemoryUserStore keeps users in a map. It mirrors the DynamoDB store,
+// including the errors it returns, and is safe for concurrent use.
+type MemoryUserStore struct {
+	mu    sync.RWMutex
+	users map[string]amazon.User
+}
+
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{users: map[string]amazon.User{}}
+}
+
+// copyUser keeps callers from mutating stored users through shared slices
+// and maps.
+func copyUser(u amazon.User) *amazon.User {
+	u.Roles = slices.Clone(u.Roles)
+	u.RecoveryCodes = slices.Clone(u.RecoveryCodes)
+	u.Preferences = maps.Clone(u.Preferences)
+	return &u
+}
+
+func (s *MemoryUserStore) emailTakenLocked(email, exceptID string) bool {
+	for _, u := range s.users {
+		if u.Email == email && u.ID != exceptID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemoryUserStore) CreateUser(user amazon.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.users[user.ID]; exists {
+		return fmt.Errorf("user with ID %s already exists", user.ID)
+	}
+	if s.emailTakenLocked(user.Email, "") {
+		return fmt.Errorf("user with email %s already exists: %w", user.Email, amazon.ErrEmailTaken)
+	}
+
+	created := copyUser(user)
+	created.Version = 1
+	s.users[user.ID] = *created
+	return nil
+}
+
+func (s *MemoryUserStore) GetUser(id string) (*amazon.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return nil, fmt.Errorf("user with ID %s: %w", id, amazon.ErrUserNotFound)
+	}
+	return copyUser(u), nil
+}
+
+func (s *MemoryUserStore) GetUserByEmail(email string) (*amazon.User, error) {
+	email = strings.ToLower(email)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, u := range s.users {
+		if u.Email == email {
+			return copyUser(u), nil
+		}
+	}
+	return nil, fmt.Errorf("user with email %s: %w", email, amazon.ErrUserNotFound)
+}
+
+// ListUsers uses ID order as its storage order.
+func (s *MemoryUserStore) ListUsers(query UserQuery) (UserPage, error) {
+	s.mu.RLock()
+	var users []amazon.User
+	for _, u := range s.users {
+		if query.Filter.Matches(u) {
+			users = append(users, *copyUser(u))
+		}
+	}
+	s.mu.RUnlock()
+
+	if query.Sort != "" {
+		return sortedPage(users, query)
+	}
+
+	after, err := decodeCursor(query.Cursor, query)
+	if err != nil {
+		return UserPage{}, err
+	}
+
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	start := sort.Search(len(users), func(i int) bool { return users[i].ID > after.ID })
+	end := min(start+query.Limit, len(users))
+
+	page := UserPage{Users: users[start:end]}
+	if end < len(users) {
+		page.NextCursor = cursor{ID: users[end-1].ID}.encode()
+	}
+	return page, nil
+}
+
+func (s *MemoryUserStore) UpdateUser(user amazon.User, version int64) error {
+	if user.Email == "" && user.Name == "" {
+		return amazon.ErrNothingToUpdate
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.users[user.ID]
+	if !ok {
+		return fmt.Errorf("user with ID %s: %w", user.ID, amazon.ErrUserNotFound)
+	}
+	if current.Version != version {
+		return fmt.Errorf("user with ID %s: %w", user.ID, amazon.ErrVersionConflict)
+	}
+
+	if user.Email != "" && user.Email != current.Email {
+		if s.emailTakenLocked(user.Email, user.ID) {
+			return fmt.Errorf("email %s: %w", user.Email, amazon.ErrEmailTaken)
+		}
+		current.Email = user.Email
+		current.EmailStatus = amazon.EmailUnverified
+	}
+	if user.Name != "" {
+		current.Name = user.Name
+	}
+
+	current.Version++
+	s.users[user.ID] = current
+	return nil
+}
+
+// update applies fn to a stored user and bumps its version, failing like a
+// conditional write when the user does not exist.
+func (s *MemoryUserStore) update(id string, fn func(u *amazon.User)) error {
+	return s.modify(id, true, func(u *amazon.User) error {
+		fn(u)
+		return nil
+	})
+}
+
+// modify is update for writes with their own condition, fn leaves the user
+// untouched when it returns an error.
+func (s *MemoryUserStore) modify(id string, bump bool, fn func(u *amazon.User) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.users[id]
+	if !ok {
+		return fmt.Errorf("user with ID %s: %w", id, amazon.ErrUserNotFound)
+	}
+	u := *copyUser(current)
+	if err := fn(&u); err != nil {
+		return err
+	}
+	if bump {
+		u.Version++
+	}
+	s.users[id] = u
+	return nil
+}
+
+func (s *MemoryUserStore) UpdatePassword(id, hash string) error {
+	if id == "" || hash == "" {
+		return fmt.Errorf("missing user ID or password")
+	}
+	return s.update(id, func(u *amazon.User) { u.Password = hash })
+}
+
+func (s *MemoryUserStore) UpdateRoles(id string, roles []string) error {
+	return s.update(id, func(u *amazon.User) { u.Roles = slices.Clone(roles) })
+}
+
+func (s *MemoryUserStore) UpdateProfile(id string, profile amazon.ProfileUpdate, version int64) error {
+	fields := []struct {
+		value  *string
+		target func(u *amazon.User) *string
+	}{
+		{profile.DisplayName, func(u *amazon.User) *string { return &u.DisplayName }},
+		{profile.Bio, func(u *amazon.User) *string { return &u.Bio }},
+		{profile.Timezone, func(u *amazon.User) *string { return &u.Timezone }},
+		{profile.Locale, func(u *amazon.User) *string { return &u.Locale }},
+	}
+
+	changed := profile.Preferences != nil || (profile.Name != nil && *profile.Name != "")
+	for _, f := range fields {
+		changed = changed || f.value != nil
+	}
+	if !changed {
+		return amazon.ErrNothingToUpdate
+	}
+
+	return s.modify(id, true, func(u *amazon.User) error {
+		if u.Version != version {
+			return fmt.Errorf("user with ID %s: %w", id, amazon.ErrVersionConflict)
+		}
+		if profile.Name != nil && *profile.Name != "" {
+			u.Name = *profile.Name
+		}
+		for _, f := range fields {
+			if f.value != nil {
+				*f.target(u) = *f.value
+			}
+		}
+		if profile.Preferences != nil {
+			u.Preferences = nil
+			if len(profile.Preferences) > 0 {
+				u.Preferences = maps.Clone(profile.Preferences)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *MemoryUserStore) SetAvatar(id, key string, version int64) error {
+	return s.modify(id, true, func(u *amazon.User) error {
+		if u.Version != version {
+			return fmt.Errorf("user with ID %s: %w", id, amazon.ErrVersionConflict)
+		}
+		u.AvatarKey = key
+		return nil
+	})
+}
+
+func (s *MemoryUserStore) SetStatus(id, status string, purgeAt int64) error {
+	return s.update(id, func(u *amazon.User) {
+		u.Status = status
+		u.PurgeAt = 0
+		if status == amazon.UserDeleted {
+			u.PurgeAt = purgeAt
+		}
+	})
+}
+
+func (s *MemoryUserStore) DeleteUser(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// deleting a missing user is not an error, same as DeleteItem
+	delete(s.users, id)
+	return nil
+}
+
+func (s *MemoryUserStore) UsersDueForPurge(now int64) ([]amazon.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var users []amazon.User
+	for _, u := range s.users {
+		if u.Status == amazon.UserDeleted && u.PurgeAt <= now {
+			users = append(users, *copyUser(u))
+		}
+	}
+	return users, nil
+}
+
+func (s *MemoryUserStore) SetEmailVerified(id string) error {
+	return s.update(id, func(u *amazon.User) {
+		u.EmailStatus = amazon.EmailVerified
+		u.VerificationSentAt = 0
+	})
+}
+
+func (s *MemoryUserStore) ClaimVerificationSend(id string, sentAt, notBefore int64) error {
+	return s.modify(id, false, func(u *amazon.User) error {
+		if u.VerificationSentAt != 0 && u.VerificationSentAt >= notBefore {
+			return amazon.ErrThrottled
+		}
+		u.VerificationSentAt = sentAt
+		return nil
+	})
+}
+
+func (s *MemoryUserStore) ClaimMagicLinkSend(id string, sentAt, notBefore int64) error {
+	return s.modify(id, false, func(u *amazon.User) error {
+		if u.MagicLinkSentAt != 0 && u.MagicLinkSentAt >= notBefore {
+			return amazon.ErrThrottled
+		}
+		u.MagicLinkSentAt = sentAt
+		return nil
+	})
+}
+
+func (s *MemoryUserStore) ClaimResetSend(id string, sentAt, notBefore int64) error {
+	return s.modify(id, false, func(u *amazon.User) error {
+		if u.ResetSentAt != 0 && u.ResetSentAt >= notBefore {
+			return amazon.ErrThrottled
+		}
+		u.ResetSentAt = sentAt
+		return nil
+	})
+}
+
+func (s *MemoryUserStore) ClaimExportRequest(id string, requestedAt, notBefore int64) error {
+	return s.modify(id, false, func(u *amazon.User) error {
+		if u.ExportRequestedAt != 0 && u.ExportRequestedAt >= notBefore {
+			return amazon.ErrThrottled
+		}
+		u.ExportRequestedAt = requestedAt
+		return nil
+	})
+}
+
+func (s *MemoryUserStore) SetPendingTOTPSecret(id, secret string) error {
+	return s.modify(id, false, func(u *amazon.User) error {
+		if u.MFAEnabled {
+			return fmt.Errorf("error storing totp secret: mfa is already enabled")
+		}
+		u.TOTPSecret = secret
+		u.TOTPLastStep = 0
+		return nil
+	})
+}
+
+func (s *MemoryUserStore) EnableMFA(id string, recoveryCodes []string, step int64) error {
+	return s.modify(id, true, func(u *amazon.User) error {
+		if u.TOTPSecret == "" {
+			return fmt.Errorf("error enabling mfa: no pending secret")
+		}
+		u.MFAEnabled = true
+		u.RecoveryCodes = slices.Clone(recoveryCodes)
+		u.TOTPLastStep = step
+		return nil
+	})
+}
+
+func (s *MemoryUserStore) DisableMFA(id string) error {
+	return s.update(id, func(u *amazon.User) {
+		u.MFAEnabled = false
+		u.TOTPSecret = ""
+		u.TOTPLastStep = 0
+		u.RecoveryCodes = nil
+	})
+}
+
+func (s *MemoryUserStore) RecordTOTPStep(id string, step int64) error {
+	return s.modify(id, false, func(u *amazon.User) error {
+		if u.TOTPLastStep != 0 && u.TOTPLastStep >= step {
+			return amazon.ErrCodeUsed
+		}
+		u.TOTPLastStep = step
+		return nil
+	})
+}
+
+func (s *MemoryUserStore) UseRecoveryCode(id string, index int, hash string) error {
+	return s.modify(id, false, func(u *amazon.User) error {
+		if index < 0 || index >= len(u.RecoveryCodes) || u.RecoveryCodes[index] != hash {
+			return amazon.ErrCodeUsed
+		}
+		u.RecoveryCodes = slices.Delete(u.RecoveryCodes, index, index+1)
+		return nil
+	})
+}
+
+// MemoryFileStore keeps file records in a map keyed by user and file ID.
+type MemoryFileStore struct {
+	mu    sync.RWMutex
+	files map[string]amazon.UserFile
+}
+
+func NewMemoryFileStore() *MemoryFileStore {
+	return &MemoryFileStore{files: map[string]amazon.UserFile{}}
+}
+
+func (s *MemoryFileStore) SaveFile(file amazon.UserFile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.files[file.UserID+"/"+file.FileID] = file
+	return nil
+}
+
+func (s *MemoryFileStore) GetUserFiles(userID string) ([]amazon.UserFile, error) {
+	return s.filter(func(f amazon.UserFile) bool { return f.UserID == userID }), nil
+}
+
+func (s *MemoryFileStore) GetOrgFiles(orgID string) ([]amazon.UserFile, error) {
+	return s.filter(func(f amazon.UserFile) bool { return f.OrgID != "" && f.OrgID == orgID }), nil
+}
+
+func (s *MemoryFileStore) DeleteFile(userID, fileID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.files, userID+"/"+fileID)
+	return nil
+}
+
+// filter returns matching files ordered by file ID, the sort key order a
+// DynamoDB query returns them in.
+func (s *MemoryFileStore) filter(match func(amazon.UserFile) bool) []amazon.UserFile {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var files []amazon.UserFile
+	for _, f := range s.files {
+		if match(f) {
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].FileID < files[j].FileID })
+	return files
+}
+
+// MemorySessionStore keeps sessions in a map keyed by session ID.
+type MemorySessionStore struct {
+	mu       sync.RWMutex
+	sessions map[string]amazon.Session
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: map[string]amazon.Session{}}
+}
+
+func (s *MemorySessionStore) CreateSession(session amazon.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.sessions[session.ID]; exists {
+		return fmt.Errorf("failed to create session: %s already exists", session.ID)
+	}
+	s.sessions[session.ID] = session
+	return nil
+}
+
+func (s *MemorySessionStore) GetSession(id string) (*amazon.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, fmt.Errorf("session %s not found", id)
+	}
+	return &session, nil
+}
+
+func (s *MemorySessionStore) GetUserSessions(userID string) ([]amazon.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var sessions []amazon.Session
+	for _, session := range s.sessions {
+		if session.UserID == userID {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ID < sessions[j].ID })
+	return sessions, nil
+}
+
+func (s *MemorySessionStore) RotateSession(oldTokenID string, next amazon.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.sessions[next.ID]
+	if !ok || current.TokenID != oldTokenID || current.Revoked {
+		return amazon.ErrTokenReused
+	}
+	current.TokenID = next.TokenID
+	current.ExpiresAt = next.ExpiresAt
+	current.LastSeenAt = next.LastSeenAt
+	current.UserAgent = next.UserAgent
+	current.IP = next.IP
+	s.sessions[next.ID] = current
+	return nil
+}
+
+func (s *MemorySessionStore) RevokeSession(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return fmt.Errorf("error revoking session: %s not found", id)
+	}
+	session.Revoked = true
+	s.sessions[id] = session
+	return nil
+}
+
+func (s *MemorySessionStore) RevokeUserSessions(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, session := range s.sessions {
+		if session.UserID == userID {
+			session.Revoked = true
+			s.sessions[id] = session
+		}
+	}
+	return nil
+}
+
+func (s *MemorySessionStore) DeleteUserSessions(userID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := 0
+	for id, session := range s.sessions {
+		if session.UserID == userID {
+			delete(s.sessions, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (s *MemorySessionStore) SetSessionOrg(id, orgID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || session.Revoked {
+		return fmt.Errorf("error setting session organization: %s is not active", id)
+	}
+	session.OrgID = orgID
+	s.sessions[id] = session
+	return nil
+}
+
+// MemoryLoginAttemptStore keeps failure counters in a map keyed by attempt
+// key.
+type MemoryLoginAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]amazon.LoginAttempts
+}
+
+func NewMemoryLoginAttemptStore() *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{attempts: map[string]amazon.LoginAttempts{}}
+}
+
+func (s *MemoryLoginAttemptStore) GetLoginAttempts(id string) (*amazon.LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts, ok := s.attempts[id]
+	if !ok {
+		return nil, nil
+	}
+	return &attempts, nil
+}
+
+func (s *MemoryLoginAttemptStore) RecordLoginFailure(id string, now, windowStart int64) (*amazon.LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts, ok := s.attempts[id]
+	if !ok || attempts.LastFailureAt < windowStart {
+		attempts = amazon.LoginAttempts{ID: id}
+	}
+	attempts.Failures++
+	attempts.LastFailureAt = now
+	s.attempts[id] = attempts
+	return &attempts, nil
+}
+
+func (s *MemoryLoginAttemptStore) SetLockout(id string, until int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts := s.attempts[id]
+	attempts.ID = id
+	attempts.LockedUntil = until
+	s.attempts[id] = attempts
+	return nil
+}
+
+func (s *MemoryLoginAttemptStore) ClearLoginAttempts(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, id)
+	return nil
+}
+
+// MemoryAPIKeyStore keeps API keys in a map keyed by key ID.
+type MemoryAPIKeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]amazon.APIKey
+}
+
+func NewMemoryAPIKeyStore() *MemoryAPIKeyStore {
+	return &MemoryAPIKeyStore{keys: map[string]amazon.APIKey{}}
+}
+
+func (s *MemoryAPIKeyStore) CreateAPIKey(key amazon.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.keys[key.ID]; exists {
+		return fmt.Errorf("failed to create api key: %s already exists", key.ID)
+	}
+	key.Scopes = slices.Clone(key.Scopes)
+	s.keys[key.ID] = key
+	return nil
+}
+
+func (s *MemoryAPIKeyStore) GetAPIKey(id string) (*amazon.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("api key %s not found", id)
+	}
+	key.Scopes = slices.Clone(key.Scopes)
+	return &key, nil
+}
+
+func (s *MemoryAPIKeyStore) GetUserAPIKeys(userID string) ([]amazon.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var keys []amazon.APIKey
+	for _, key := range s.keys {
+		if key.UserID == userID {
+			key.Scopes = slices.Clone(key.Scopes)
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, nil
+}
+
+func (s *MemoryAPIKeyStore) RevokeAPIKey(id, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok || key.UserID != userID {
+		return fmt.Errorf("api key %s not found", id)
+	}
+	key.Revoked = true
+	s.keys[id] = key
+	return nil
+}
+
+func (s *MemoryAPIKeyStore) DeleteAPIKey(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.keys, id)
+	return nil
+}
+
+func (s *MemoryAPIKeyStore) TouchAPIKey(id string, usedAt int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return fmt.Errorf("api key %s not found", id)
+	}
+	key.LastUsedAt = usedAt
+	s.keys[id] = key
+	return nil
+}
+
+// NewMemoryStores returns empty in-memory stores, for tests and local runs
+// without AWS.
+func NewMemoryStores() Stores {
+	return Stores{
+		Users:         NewMemoryUserStore(),
+		Files:         NewMemoryFileStore(),
+		Sessions:      NewMemorySessionStore(),
+		LoginAttempts: NewMemoryLoginAttemptStore(),
+		APIKeys:       NewMemoryAPIKeyStore(),
+	}
+}
+
+var (
+	_ UserStore         = (*MemoryUserStore)(nil)
+	_ FileStore         = (*MemoryFileStore)(nil)
+	_ SessionStore      = (*MemorySessionStore)(nil)
+	_ LoginAttemptStore = (*MemoryLoginAttemptStore)(nil)
+	_ APIKeyStore       = (*MemoryAPIKeyStore)(nil)
+)
@@ -0,0 +1,128 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"xstudious-guide/amazon"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Sortable user fields. Without a sort users come back in storage order,
+// which is cheap to page through. Sorting has to look at every matching user.
+const (
+	SortName      = "name"
+	SortEmail     = "email"
+	SortCreatedAt = "createdAt"
+)
+
+func IsUserSort(field string) bool {
+	return field == SortName || field == SortEmail || field == SortCreatedAt
+}
+
+type UserQuery struct {
+	Filter amazon.UserFilter
+	Sort   string // empty for storage order
+	Desc   bool
+	Limit  int
+	Cursor string // NextCursor of the previous page
+}
+
+type UserPage struct {
+	Users      []amazon.User
+	NextCursor string // empty on the last page
+}
+
+// cursor is what NextCursor encodes. ID alone resumes a storage order
+// listing, sorted listings also need the sort value of the last user.
+type cursor struct {
+	ID    string `json:"id"`
+	Sort  string `json:"s,omitempty"`
+	Value string `json:"v,omitempty"`
+}
+
+func (c cursor) encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeCursor checks that the cursor was made for the same ordering.
+func decodeCursor(s string, q UserQuery) (cursor, error) {
+	var c cursor
+	if s == "" {
+		return c, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(raw, &c) != nil || c.ID == "" {
+		return cursor{}, ErrInvalidCursor
+	}
+	if c.Sort != sortTag(q) {
+		return cursor{}, fmt.Errorf("%w: made for a different sort", ErrInvalidCursor)
+	}
+	return c, nil
+}
+
+func sortTag(q UserQuery) string {
+	if q.Sort == "" {
+		return ""
+	}
+	if q.Desc {
+		return "-" + q.Sort
+	}
+	return q.Sort
+}
+
+// sortValue returns a string that orders like the field, numbers are zero
+// padded.
+func sortValue(u amazon.User, field string) string {
+	switch field {
+	case SortName:
+		return strings.ToLower(u.Name)
+	case SortEmail:
+		return u.Email
+	case SortCreatedAt:
+		return fmt.Sprintf("%020d", u.CreatedAt)
+	default:
+		return ""
+	}
+}
+
+// sortedPage orders users, which must already be filtered, and returns the
+// page after the cursor. Ties are broken by ID so the order is total.
+func sortedPage(users []amazon.User, q UserQuery) (UserPage, error) {
+	after, err := decodeCursor(q.Cursor, q)
+	if err != nil {
+		return UserPage{}, err
+	}
+
+	less := func(aVal, aID, bVal, bID string) bool {
+		if aVal != bVal {
+			return (aVal < bVal) != q.Desc
+		}
+		return aID < bID
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return less(sortValue(users[i], q.Sort), users[i].ID, sortValue(users[j], q.Sort), users[j].ID)
+	})
+
+	start := 0
+	if after.ID != "" {
+		start = sort.Search(len(users), func(i int) bool {
+			return less(after.Value, after.ID, sortValue(users[i], q.Sort), users[i].ID)
+		})
+	}
+
+	end := min(start+q.Limit, len(users))
+	page := UserPage{Users: users[start:end]}
+	if end < len(users) {
+		last := users[end-1]
+		page.NextCursor = cursor{ID: last.ID, Sort: sortTag(q), Value: sortValue(last, q.Sort)}.encode()
+	}
+	return page, nil
+}
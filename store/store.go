@@ -0,0 +1,106 @@
+// Package store defines the storage interfaces the HTTP handlers depend on.
+// The DynamoDB implementation wraps the amazon package; the in-memory one has
+// the same semantics and needs no AWS account.
+package store
+
+import "xstudious-guide/amazon"
+
+// UserStore errors wrap amazon.ErrUserNotFound, amazon.ErrEmailTaken,
+// amazon.ErrNothingToUpdate and amazon.ErrVersionConflict, so callers can
+// check them with errors.Is. Every write that changes the account increments
+// the user's Version; bookkeeping of sent emails and used MFA codes does not.
+type UserStore interface {
+	CreateUser(user amazon.User) error
+	GetUser(id string) (*amazon.User, error)
+	GetUserByEmail(email string) (*amazon.User, error)
+	// ListUsers returns one page of users. A cursor that cannot be decoded
+	// or was made for another sort fails with ErrInvalidCursor.
+	ListUsers(query UserQuery) (UserPage, error)
+
+	// UpdateUser changes the non-empty name and email of user.ID if it is
+	// still at version. A new email is marked unverified.
+	UpdateUser(user amazon.User, version int64) error
+	UpdatePassword(id, hash string) error
+	UpdateRoles(id string, roles []string) error
+	// UpdateProfile and SetAvatar only write while the user is at version,
+	// and fail with amazon.ErrVersionConflict otherwise.
+	UpdateProfile(id string, profile amazon.ProfileUpdate, version int64) error
+	SetAvatar(id, key string, version int64) error
+	// SetStatus changes the account state, purgeAt only applies to
+	// amazon.UserDeleted.
+	SetStatus(id, status string, purgeAt int64) error
+	DeleteUser(id string) error
+	// UsersDueForPurge lists deleted users whose grace period ended by now.
+	UsersDueForPurge(now int64) ([]amazon.User, error)
+
+	SetEmailVerified(id string) error
+	// ClaimVerificationSend fails with amazon.ErrThrottled if the previous
+	// verification email was sent after notBefore.
+	ClaimVerificationSend(id string, sentAt, notBefore int64) error
+	// ClaimMagicLinkSend and ClaimResetSend do the same for sign-in and
+	// password reset links.
+	ClaimMagicLinkSend(id string, sentAt, notBefore int64) error
+	ClaimResetSend(id string, sentAt, notBefore int64) error
+	// ClaimExportRequest fails with amazon.ErrThrottled if the previous data
+	// export was requested after notBefore.
+	ClaimExportRequest(id string, requestedAt, notBefore int64) error
+
+	// MFA codes can be used once, a replayed TOTP step or recovery code
+	// fails with amazon.ErrCodeUsed.
+	SetPendingTOTPSecret(id, secret string) error
+	EnableMFA(id string, recoveryCodes []string, step int64) error
+	DisableMFA(id string) error
+	RecordTOTPStep(id string, step int64) error
+	UseRecoveryCode(id string, index int, hash string) error
+}
+
+type FileStore interface {
+	SaveFile(file amazon.UserFile) error
+	GetUserFiles(userID string) ([]amazon.UserFile, error)
+	GetOrgFiles(orgID string) ([]amazon.UserFile, error)
+	DeleteFile(userID, fileID string) error
+}
+
+// SessionStore keeps refresh token families. RotateSession fails with
+// amazon.ErrTokenReused unless oldTokenID is still current and the session
+// is not revoked.
+type SessionStore interface {
+	CreateSession(session amazon.Session) error
+	GetSession(id string) (*amazon.Session, error)
+	GetUserSessions(userID string) ([]amazon.Session, error)
+	RotateSession(oldTokenID string, next amazon.Session) error
+	RevokeSession(id string) error
+	RevokeUserSessions(userID string) error
+	// DeleteUserSessions returns how many sessions were removed.
+	DeleteUserSessions(userID string) (int, error)
+	SetSessionOrg(id, orgID string) error
+}
+
+// LoginAttemptStore counts failed logins per key, see amazon.LoginAttempts.
+type LoginAttemptStore interface {
+	// GetLoginAttempts returns nil without an error if the key has no failures.
+	GetLoginAttempts(id string) (*amazon.LoginAttempts, error)
+	// RecordLoginFailure forgets failures older than windowStart.
+	RecordLoginFailure(id string, now, windowStart int64) (*amazon.LoginAttempts, error)
+	SetLockout(id string, until int64) error
+	ClearLoginAttempts(id string) error
+}
+
+type APIKeyStore interface {
+	CreateAPIKey(key amazon.APIKey) error
+	GetAPIKey(id string) (*amazon.APIKey, error)
+	GetUserAPIKeys(userID string) ([]amazon.APIKey, error)
+	// RevokeAPIKey reports a key owned by someone else as not found.
+	RevokeAPIKey(id, userID string) error
+	DeleteAPIKey(id string) error
+	TouchAPIKey(id string, usedAt int64) error
+}
+
+// Stores bundles the stores that the routes and the auth middleware share.
+type Stores struct {
+	Users         UserStore
+	Files         FileStore
+	Sessions      SessionStore
+	LoginAttempts LoginAttemptStore
+	APIKeys       APIKeyStore
+}